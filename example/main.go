@@ -16,19 +16,11 @@ type Article struct {
 
 type Comment struct {
 	Id        string `json:"_id"`
-	ArticleId string `json:"articleId"`
-	Author    string `json:"author"`
+	ArticleId string `json:"articleId" idb:"byArticle"`
+	Author    string `json:"author" idb:"byAuthor"`
 	Body      string `json:"comment"`
 }
 
-func (a Comment) Keys(idx string) []indexeddb.Key {
-	switch idx {
-	case "byAuthor":
-		return []indexeddb.Key{{a.Author}}
-	}
-	return []indexeddb.Key{}
-}
-
 func migrate(version uint, h *indexeddb.MigrationTransaction) error {
 	fmt.Printf("migrating from version %d\n", version)
 	switch version {
@@ -42,7 +34,7 @@ func migrate(version uint, h *indexeddb.MigrationTransaction) error {
 		if err != nil {
 			return err
 		}
-		return comment.CreateIndex("byAuthor", indexeddb.IndexOptions{})
+		return comment.CreateIndexesFor(Comment{})
 	}
 	panic("could not migrate database")
 }
@@ -62,12 +54,14 @@ func main() {
 		panic(err)
 	}
 
-	a := t.Store("article")
+	a, err := t.Store("article")
+	if err != nil {
+		panic(err)
+	}
 
 	// get all articles
 
-	var articles []Article
-	a.GetAll(indexeddb.All(), 0, &articles)
+	articles, err := indexeddb.NewTypedStore[string, Article](a).GetAll(indexeddb.All(), 0)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,114 @@
+package indexeddb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBulkAdd(t *testing.T) {
+	db := openTaskDatabase(t)
+
+	tr, err := db.Transaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+	s := must(tr.Store("tasks"))
+
+	items := []KeyValue{
+		{Value: testTask{"e", "open"}},
+		{Value: testTask{"a", "open"}},
+		{Key: Key{"f"}, Value: testTask{"f", "done"}},
+		{Value: testTask{"e", "again"}},
+	}
+	keys, err := s.BulkAdd(items, BulkOptions{})
+
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("expected a bulk error, got %v", err)
+	}
+	if len(bulkErr.Errors) != 2 || !errors.Is(bulkErr.Errors[1], ErrConstraint) || !errors.Is(bulkErr.Errors[3], ErrConstraint) {
+		t.Errorf("expected items 1 and 3 to fail the uniqueness check, got %v", bulkErr.Errors)
+	}
+	if !errors.Is(err, ErrConstraint) {
+		t.Error("bulk errors should match the errors of their items")
+	}
+	var itemErr *Error
+	if !errors.As(err, &itemErr) || itemErr.Name != ConstraintError {
+		t.Errorf("expected errors.As to find the first item error, got %v", itemErr)
+	}
+	if !reflect.DeepEqual(keys, []Key{{"e"}, nil, {"f"}, nil}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	var out testTask
+	if err := s.GetExact(Key{"e"}, &out); err != nil || out.Status != "open" {
+		t.Errorf("expected the first item to be stored, got %v (%v)", out, err)
+	}
+	if n, _ := must(s.Index("byStatus")).Count(Only(Key{"done"})); n != 1 {
+		t.Errorf("expected index entries for the stored items, got %d", n)
+	}
+}
+
+func TestBulkAtomic(t *testing.T) {
+	db := openTaskDatabase(t)
+
+	tr, err := db.Transaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+	s := must(tr.Store("tasks"))
+
+	keys, err := s.BulkAdd([]KeyValue{{Value: testTask{"e", "open"}}, {Value: testTask{"a", "open"}}}, BulkOptions{Atomic: true})
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) || !bulkErr.Atomic || keys != nil {
+		t.Fatalf("expected an atomic bulk error, got %v, %v", keys, err)
+	}
+	if n, _ := s.Count(All()); n != 4 {
+		t.Errorf("atomic bulk writes should store nothing on failure, got %d records", n)
+	}
+}
+
+func TestBulkPutDelete(t *testing.T) {
+	db := openTaskDatabase(t)
+
+	tr, err := db.Transaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+	s := must(tr.Store("tasks"))
+
+	// later items see the earlier ones
+	_, err = s.BulkPut([]KeyValue{
+		{Value: testTask{"a", "done"}},
+		{Value: testTask{"e", "open"}},
+		{Value: testTask{"e", "done"}},
+	}, BulkOptions{Atomic: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := must(s.Index("byStatus"))
+	if n, _ := idx.Count(Only(Key{"done"})); n != 2 {
+		t.Errorf("expected 2 done entries, got %d", n)
+	}
+	if n, _ := idx.Count(All()); n != 5 {
+		t.Errorf("replaced values should not leave stale index entries, got %d", n)
+	}
+
+	if err := s.BulkDelete([]Key{{"a"}, {"b"}, {"missing"}}, BulkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := s.GetAllKeys(All(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []Key{{"c"}, {"d"}, {"e"}}) {
+		t.Errorf("unexpected keys after delete %v", keys)
+	}
+	if n, _ := idx.Count(All()); n != 3 {
+		t.Errorf("expected the index entries of deleted records to be removed, got %d", n)
+	}
+}
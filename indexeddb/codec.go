@@ -0,0 +1,39 @@
+package indexeddb
+
+import (
+	"github.com/huffduff/go-indexeddb/indexeddb/internal"
+)
+
+// Codec encodes the values kept in a store. Its name is recorded in the store
+// spec, so a codec must keep its name and format once data has been stored.
+type Codec = internal.Codec
+
+// TypedCodec is a codec that decodes records into values of a known type when
+// an index is built over them, see NewGobCodec
+type TypedCodec = internal.TypedCodec
+
+var (
+	// JSONCodec stores values with encoding/json, the default
+	JSONCodec = internal.JSONCodec
+	// GobCodec stores values with encoding/gob. Gob cannot decode into an empty
+	// interface, so indexes can only be created on its stores before they hold
+	// records. Use NewGobCodec to index existing records.
+	GobCodec = internal.GobCodec
+	// BinaryCodec stores the JSON form of values in a compact tagged binary format
+	BinaryCodec = internal.BinaryCodec
+)
+
+// RegisterCodec makes a custom codec available to the stores using it. Stores
+// created with a codec register it, but it has to be registered again before
+// the database is opened by a new process. Registering a different codec
+// under a name already taken returns a ConstraintError.
+func RegisterCodec(c Codec) error {
+	return internal.RegisterCodec(c)
+}
+
+// NewGobCodec returns a gob codec for values of the type of prototype, stored
+// under name. Unlike GobCodec, indexes can be created on its stores once they
+// hold records. Register it before opening a database that uses it.
+func NewGobCodec(name string, prototype interface{}) TypedCodec {
+	return internal.NewGobCodec(name, prototype)
+}
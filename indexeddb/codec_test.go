@@ -0,0 +1,122 @@
+package indexeddb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type codecItem struct {
+	Id   string `json:"_id"`
+	Name string `json:"name"`
+}
+
+func TestStoreCodecs(t *testing.T) {
+	f := NewFactory(t.TempDir())
+	items := []codecItem{{"1", "a"}, {"2", "b"}}
+
+	db, err := f.Open("db", 1).Codec(BinaryCodec).Migrate(func(_ uint, h *MigrationTransaction) error {
+		for name, c := range map[string]Codec{"json": JSONCodec, "gob": GobCodec, "default": nil} {
+			s, err := h.CreateStore(name, StoreOptions{KeyPath: KeyPath{"_id"}, Codec: c})
+			if err != nil {
+				return err
+			}
+			if err := s.CreateIndex("byName", IndexOptions{KeyPath: KeyPath{"name"}}); err != nil {
+				return err
+			}
+			for _, item := range items {
+				if _, err := s.Put(item); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = f.Open("db", 1).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rt, err := db.ReadonlyTransaction([]string{"json", "gob", "default"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]Codec{"json": JSONCodec, "gob": GobCodec, "default": BinaryCodec} {
+		s := must(rt.Store(name))
+		if s.Codec() != expected {
+			t.Errorf("%s: expected codec %s, got %v", name, expected.Name(), s.Codec())
+		}
+
+		var item codecItem
+		if err := s.GetExact(Key{"2"}, &item); err != nil || item != items[1] {
+			t.Errorf("%s: expected %v, got %v %v", name, items[1], item, err)
+		}
+		var all []codecItem
+		if err := s.GetAll(All(), 0, &all); err != nil || !reflect.DeepEqual(all, items) {
+			t.Errorf("%s: expected %v, got %v %v", name, items, all, err)
+		}
+		var multi []codecItem
+		if err := s.GetMulti([]Key{{"2"}, {"3"}}, &multi); err != nil || !reflect.DeepEqual(multi, []codecItem{items[1], {}}) {
+			t.Errorf("%s: unexpected GetMulti result %v %v", name, multi, err)
+		}
+
+		c, err := must(s.Index("byName")).OpenCursor(Only(Key{"a"}), Next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.Continue() {
+			t.Fatalf("%s: expected an index entry", name)
+		}
+		if err := c.Value(&item); err != nil || item != items[0] {
+			t.Errorf("%s: expected the index cursor to decode %v, got %v %v", name, items[0], item, err)
+		}
+	}
+	rt.Commit()
+	db.Close()
+
+	// gob values cannot be decoded without their type, so existing records cannot be indexed
+	_, err = f.Open("db", 2).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.Store("gob")
+		if err != nil {
+			return err
+		}
+		return s.CreateIndex("late", IndexOptions{KeyPath: KeyPath{"name"}})
+	})
+	if !errors.Is(err, ErrData) {
+		t.Errorf("expected backfilling a gob store to fail, got %v", err)
+	}
+
+	// typed gob codecs know what to decode into
+	typed := NewGobCodec("gobItem", codecItem{})
+	db, err = f.Open("db", 2).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.CreateStore("typed", StoreOptions{KeyPath: KeyPath{"_id"}, Codec: typed})
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if _, err := s.Put(item); err != nil {
+				return err
+			}
+		}
+		return s.CreateIndex("typedByName", IndexOptions{KeyPath: KeyPath{"name"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rt, err = db.ReadonlyTransaction([]string{"typed"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+	var item codecItem
+	if err := must(must(rt.Store("typed")).Index("typedByName")).Get(Only(Key{"b"}), &item); err != nil || item != items[1] {
+		t.Errorf("expected the typed gob store to be indexed, got %v %v", item, err)
+	}
+}
@@ -0,0 +1,415 @@
+package indexeddb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testTask struct {
+	Id     string `json:"_id"`
+	Status string `json:"status"`
+}
+
+func openTaskDatabase(t *testing.T) *Database {
+	t.Helper()
+	db, err := NewFactory(t.TempDir()).Open("tasks", 1).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.CreateStore("tasks", StoreOptions{KeyPath: KeyPath{"_id"}})
+		if err != nil {
+			return err
+		}
+		if err := s.CreateIndex("byStatus", IndexOptions{KeyPath: KeyPath{"status"}}); err != nil {
+			return err
+		}
+		for _, task := range []testTask{{"a", "open"}, {"b", "open"}, {"c", "stale"}, {"d", "open"}} {
+			if _, err := s.Put(task); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestCursorUpdateDelete(t *testing.T) {
+	db := openTaskDatabase(t)
+
+	tr, err := db.Transaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := must(tr.Store("tasks")).OpenCursor(All(), Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for c.Continue() {
+		var task testTask
+		if err := c.Value(&task); err != nil {
+			t.Fatal(err)
+		}
+		switch task.Status {
+		case "stale":
+			if err := c.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		case "open":
+			if task.Id == "b" {
+				if err := c.Update(testTask{"other", "done"}); err == nil {
+					t.Error("expected updating with a different key to fail")
+				}
+			}
+			task.Status = "done"
+			if err := c.Update(task); err != nil {
+				t.Fatal(err)
+			}
+			var updated testTask
+			if err := c.Value(&updated); err != nil || updated != task {
+				t.Errorf("expected the cursor value to be updated to %v, got %v %v", task, updated, err)
+			}
+		}
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := db.ReadonlyTransaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+
+	s := must(rt.Store("tasks"))
+	if n, _ := s.Count(All()); n != 3 {
+		t.Errorf("expected 3 records, got %d", n)
+	}
+	for status, expected := range map[string]uint{"open": 0, "stale": 0, "done": 3} {
+		if n, _ := must(s.Index("byStatus")).Count(Only(Key{status})); n != expected {
+			t.Errorf("expected %d %s entries, got %d", expected, status, n)
+		}
+	}
+	var task testTask
+	if err := s.GetExact(Key{"b"}, &task); err != nil || task.Status != "done" {
+		t.Errorf("expected b to be done, got %v %v", task, err)
+	}
+
+	c, err = s.OpenCursor(All(), Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Continue() {
+		t.Fatal("expected a record")
+	}
+	if err := c.Delete(); err == nil {
+		t.Error("expected delete to fail in a readonly transaction")
+	}
+	if err := c.Update(task); err == nil {
+		t.Error("expected update to fail in a readonly transaction")
+	}
+}
+
+func collectKeys(t *testing.T, c KeyCursor) []Key {
+	t.Helper()
+	out := []Key{}
+	for c.Continue() {
+		out = append(out, c.PrimaryKey())
+	}
+	return out
+}
+
+func TestCursorDirections(t *testing.T) {
+	db, err := NewFactory(t.TempDir()).Open("posts", 1).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.CreateStore("posts", StoreOptions{KeyPath: KeyPath{"_id"}})
+		if err != nil {
+			return err
+		}
+		if err := s.CreateIndex("byAuthor", IndexOptions{KeyPath: KeyPath{"author"}}); err != nil {
+			return err
+		}
+		for id, author := range map[float64]string{1: "bo", 2: "al", 3: "bo", 4: "cy", 5: "al", 6: "bo"} {
+			if _, err := s.Put(map[string]interface{}{"_id": id, "author": author}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rt, err := db.ReadonlyTransaction([]string{"posts"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+	s := must(rt.Store("posts"))
+
+	for _, c := range []struct {
+		name     string
+		open     func() (KeyCursor, error)
+		expected []Key
+	}{
+		{"store next", func() (KeyCursor, error) { return s.OpenKeyCursor(All(), Next) }, []Key{{1.0}, {2.0}, {3.0}, {4.0}, {5.0}, {6.0}}},
+		{"store prev", func() (KeyCursor, error) { return s.OpenKeyCursor(Bound(Key{2.0}, Key{5.0}, false, true), Prev) }, []Key{{4.0}, {3.0}, {2.0}}},
+		{"store prevunique", func() (KeyCursor, error) { return s.OpenKeyCursor(UpperBound(Key{2.0}, false), PrevUnique) }, []Key{{2.0}, {1.0}}},
+		{"index next", func() (KeyCursor, error) { return must(s.Index("byAuthor")).OpenKeyCursor(All(), Next) }, []Key{{2.0}, {5.0}, {1.0}, {3.0}, {6.0}, {4.0}}},
+		{"index prev", func() (KeyCursor, error) { return must(s.Index("byAuthor")).OpenKeyCursor(All(), Prev) }, []Key{{4.0}, {6.0}, {3.0}, {1.0}, {5.0}, {2.0}}},
+		{"index nextunique", func() (KeyCursor, error) { return must(s.Index("byAuthor")).OpenKeyCursor(All(), NextUnique) }, []Key{{2.0}, {1.0}, {4.0}}},
+		{"index prevunique", func() (KeyCursor, error) { return must(s.Index("byAuthor")).OpenKeyCursor(All(), PrevUnique) }, []Key{{4.0}, {1.0}, {2.0}}},
+		{"index prevunique range", func() (KeyCursor, error) {
+			return must(s.Index("byAuthor")).OpenKeyCursor(UpperBound(Key{"bo"}, false), PrevUnique)
+		}, []Key{{1.0}, {2.0}}},
+	} {
+		cursor, err := c.open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys := collectKeys(t, cursor); !reflect.DeepEqual(keys, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, keys)
+		}
+	}
+
+	cursor, err := must(s.Index("byAuthor")).OpenKeyCursor(All(), PrevUnique)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.ContinueTo(Key{"bz"}); err != nil {
+		t.Fatal(err)
+	}
+	if key := cursor.PrimaryKey(); !reflect.DeepEqual(key, Key{1.0}) {
+		t.Errorf("expected reverse ContinueTo to land on the first bo entry, got %v", key)
+	}
+	for _, key := range []Key{{"bo"}, {"bz"}} {
+		if err := cursor.ContinueTo(key); !errors.Is(err, ErrData) {
+			t.Errorf("expected reverse ContinueTo %v to be rejected, got %v", key, err)
+		}
+	}
+	if !cursor.Advance(1) || !reflect.DeepEqual(cursor.PrimaryKey(), Key{2.0}) {
+		t.Errorf("expected to advance to al, got %v", cursor.PrimaryKey())
+	}
+	if cursor.Continue() {
+		t.Error("expected the cursor to be exhausted")
+	}
+
+	store, err := s.OpenKeyCursor(All(), Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ContinueTo(Key{3.0}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []Key{{2.0}, {3.0}} {
+		if err := store.ContinueTo(key); !errors.Is(err, ErrData) {
+			t.Errorf("expected ContinueTo %v to be rejected, got %v", key, err)
+		}
+	}
+	if err := store.ContinueTo(Key{5.0}); err != nil || !reflect.DeepEqual(store.PrimaryKey(), Key{5.0}) {
+		t.Errorf("expected to continue to 5, got %v %v", store.PrimaryKey(), err)
+	}
+}
+
+func TestIndexCursorValue(t *testing.T) {
+	db := openTaskDatabase(t)
+
+	rt, err := db.ReadonlyTransaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+	idx := must(must(rt.Store("tasks")).Index("byStatus"))
+
+	// page through the index two entries at a time
+	var pages [][]string
+	var lastKey, lastPrimaryKey Key
+	for {
+		c, err := idx.OpenCursor(All(), Next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok := c.Continue()
+		if lastKey != nil {
+			ok = c.ContinuePrimaryKey(lastKey, lastPrimaryKey) == nil && c.Continue()
+		}
+		page := []string{}
+		for ; ok && len(page) < 2; ok = len(page) < 2 && c.Continue() {
+			key, err := c.Key()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var task testTask
+			if err := c.Value(&task); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(key, Key{task.Status}) || !reflect.DeepEqual(c.PrimaryKey(), Key{task.Id}) {
+				t.Errorf("cursor keys %v %v do not match %v", key, c.PrimaryKey(), task)
+			}
+			page = append(page, task.Id)
+			lastKey, lastPrimaryKey = key, c.PrimaryKey()
+		}
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, page)
+	}
+	expected := [][]string{{"a", "b"}, {"d", "c"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("expected pages %v, got %v", expected, pages)
+	}
+
+	c, err := idx.OpenCursor(All(), Prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ContinuePrimaryKey(Key{"open"}, Key{"c"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.PrimaryKey(), Key{"b"}) {
+		t.Errorf("expected reverse cursors to land before the primary key, got %v", c.PrimaryKey())
+	}
+	for _, pk := range []Key{{"b"}, {"c"}} {
+		if err := c.ContinuePrimaryKey(Key{"open"}, pk); !errors.Is(err, ErrData) {
+			t.Errorf("expected reverse ContinuePrimaryKey to %v to be rejected, got %v", pk, err)
+		}
+	}
+	if err := c.ContinuePrimaryKey(Key{"open"}, Key{"a"}); err != nil || !reflect.DeepEqual(c.PrimaryKey(), Key{"a"}) {
+		t.Errorf("expected to continue to a, got %v %v", c.PrimaryKey(), err)
+	}
+
+	c, err = idx.OpenCursor(All(), NextUnique)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ContinuePrimaryKey(Key{"open"}, Key{"a"}); err == nil {
+		t.Error("expected unique directions to be rejected")
+	}
+}
+
+func TestCursorToken(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewFactory(dir).Open("tasks", 1).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.CreateStore("tasks", StoreOptions{KeyPath: KeyPath{"_id"}})
+		if err != nil {
+			return err
+		}
+		if err := s.CreateIndex("byStatus", IndexOptions{KeyPath: KeyPath{"status"}}); err != nil {
+			return err
+		}
+		for _, task := range []testTask{{"a", "open"}, {"b", "open"}, {"c", "stale"}, {"d", "open"}} {
+			if _, err := s.Put(task); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// token returns the token after advancing a new cursor count times
+	token := func(open func(s *ReadonlyStore) (KeyCursor, error), count int) string {
+		t.Helper()
+		rt, err := db.ReadonlyTransaction([]string{"tasks"}, Default)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rt.Commit()
+		c, err := open(must(rt.Store("tasks")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.Advance(count) {
+			t.Fatal("expected the cursor to reach its position")
+		}
+		return must(c.Token())
+	}
+	// rest lists the primary keys of a cursor resumed from token
+	rest := func(resume func(s *ReadonlyStore) (KeyCursor, error)) []Key {
+		t.Helper()
+		rt, err := db.ReadonlyTransaction([]string{"tasks"}, Default)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rt.Commit()
+		c, err := resume(must(rt.Store("tasks")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []Key
+		for c.Continue() {
+			out = append(out, c.PrimaryKey())
+		}
+		return out
+	}
+
+	cases := []struct {
+		name     string
+		index    string
+		query    Range
+		dir      Direction
+		count    int
+		expected []Key
+	}{
+		{"store", "", All(), Next, 2, []Key{{"c"}, {"d"}}},
+		{"store reverse", "", Bound(Key{"a"}, Key{"c"}, false, false), Prev, 1, []Key{{"b"}, {"a"}}},
+		{"index", "byStatus", Only(Key{"open"}), Next, 2, []Key{{"d"}}},
+		{"index reverse", "byStatus", All(), Prev, 2, []Key{{"b"}, {"a"}}},
+		{"index unique", "byStatus", All(), NextUnique, 1, []Key{{"c"}}},
+		{"index reverse unique", "byStatus", All(), PrevUnique, 1, []Key{{"a"}}},
+	}
+	for _, c := range cases {
+		tok := token(func(s *ReadonlyStore) (KeyCursor, error) {
+			if c.index != "" {
+				return must(s.Index(c.index)).OpenCursor(c.query, c.dir)
+			}
+			return s.OpenCursor(c.query, c.dir)
+		}, c.count)
+		keys := rest(func(s *ReadonlyStore) (KeyCursor, error) {
+			if c.index != "" {
+				return must(s.Index(c.index)).ResumeCursor(tok)
+			}
+			return s.ResumeCursor(tok)
+		})
+		if !reflect.DeepEqual(keys, c.expected) {
+			t.Errorf("%s: expected %v after the token, got %v", c.name, c.expected, keys)
+		}
+	}
+
+	tok := token(func(s *ReadonlyStore) (KeyCursor, error) { return s.OpenCursor(All(), Next) }, 1)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewFactory(dir).Open("tasks", 1).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if keys := rest(func(s *ReadonlyStore) (KeyCursor, error) { return s.ResumeCursor(tok) }); !reflect.DeepEqual(keys, []Key{{"b"}, {"c"}, {"d"}}) {
+		t.Errorf("expected tokens to survive reopening the database, got %v", keys)
+	}
+
+	rt, err := db.ReadonlyTransaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+	s := must(rt.Store("tasks"))
+	if c, err := must(s.Index("byStatus")).ResumeCursor(tok); !errors.Is(err, ErrData) || c != nil {
+		t.Errorf("expected a store token to be refused by an index with a nil cursor, got %v %v", c, err)
+	}
+	tampered := []byte(tok)
+	tampered[3] ^= 1
+	if c, err := s.ResumeCursor(string(tampered)); !errors.Is(err, ErrData) || c != nil {
+		t.Errorf("expected a tampered token to be refused with a nil cursor, got %v %v", c, err)
+	}
+	c, err := s.OpenCursor(All(), Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Token(); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected a cursor without a position to have no token, got %v", err)
+	}
+}
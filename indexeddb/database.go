@@ -1,11 +1,19 @@
 package indexeddb
 
 import (
+	"context"
+
 	"github.com/huffduff/go-indexeddb/indexeddb/internal"
 )
 
 type Database struct {
-	def *internal.Database
+	def     *internal.Database
+	factory *Factory
+	conn    *connection
+
+	onVersionChange func(oldVersion, newVersion uint)
+	// durability replaces Default for transactions on this handle
+	durability TransactionDurability
 }
 
 func (p *Database) Name() string {
@@ -20,10 +28,119 @@ func (p *Database) StoreNames() []string {
 	return p.def.StoreNames()
 }
 
+// Transaction starts a read-write transaction over the stores in scope.
+// Durability controls whether Commit waits for the changes to be synced to disk.
+// It blocks until every earlier read-write transaction sharing a store with
+// scope has been committed or aborted.
 func (p *Database) Transaction(scope []string, durability TransactionDurability) (*Transaction, error) {
-	return newTransaction(p.def, scope, durability)
+	return p.TransactionContext(context.Background(), scope, durability)
+}
+
+// TransactionContext starts a read-write transaction like Transaction, giving up
+// waiting for other transactions once ctx is done. Once ctx is done the transaction
+// is aborted, letting waiting transactions start, its operations return ctx.Err()
+// and open cursors stop.
+func (p *Database) TransactionContext(ctx context.Context, scope []string, durability TransactionDurability) (*Transaction, error) {
+	scope, err := checkScope(p.def, scope)
+	if err != nil {
+		return nil, err
+	}
+	sync, err := durability.synced(p.durability)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	release, err := p.conn.scheduler.acquire(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTransaction(p.def, scope, durability)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	t.sync = sync
+	t.release = release
+	t.setContext(ctx)
+	return t, nil
+}
+
+// Update runs fn in a read-write transaction over scope. The transaction is
+// committed when fn returns nil, and aborted when fn returns an error or panics.
+// Panics are passed on once the transaction has been aborted.
+func (p *Database) Update(scope []string, durability TransactionDurability, fn func(tx *Transaction) error) error {
+	return p.UpdateContext(context.Background(), scope, durability, fn)
+}
+
+// UpdateContext runs fn like Update in a transaction started with TransactionContext
+func (p *Database) UpdateContext(ctx context.Context, scope []string, durability TransactionDurability, fn func(tx *Transaction) error) error {
+	tx, err := p.TransactionContext(ctx, scope, durability)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.abort(internal.NewError(AbortError, "transaction callback panicked: %v", r))
+			panic(r)
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		tx.abort(err)
+		return err
+	}
+
+	// fn may have finished the transaction itself
+	tx.mu.Lock()
+	state, committed := tx.state, tx.committed
+	tx.mu.Unlock()
+	if state == TransactionFinished {
+		if committed {
+			return nil
+		}
+		return ErrAbort
+	}
+	return tx.Commit()
 }
 
+// ReadonlyTransaction starts a transaction reading a snapshot of the stores in scope.
+// Readonly transactions never wait for other transactions.
 func (p *Database) ReadonlyTransaction(scope []string, durability TransactionDurability) (*ReadonlyTransaction, error) {
-	return newReadonlyTransaction(p.def, scope, durability)
+	return p.ReadonlyTransactionContext(context.Background(), scope, durability)
+}
+
+// ReadonlyTransactionContext starts a readonly transaction that is aborted once
+// ctx is done
+func (p *Database) ReadonlyTransactionContext(ctx context.Context, scope []string, durability TransactionDurability) (*ReadonlyTransaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	scope, err := checkScope(p.def, scope)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newReadonlyTransaction(p.def, scope, durability)
+	if err != nil {
+		return nil, err
+	}
+	t.setContext(ctx)
+	return t, nil
+}
+
+// OnVersionChange registers a callback fired when another connection wants to
+// upgrade or delete the database. The upgrade is blocked until this handle is closed.
+func (p *Database) OnVersionChange(cb func(oldVersion, newVersion uint)) {
+	p.factory.mu.Lock()
+	defer p.factory.mu.Unlock()
+	p.onVersionChange = cb
+}
+
+// Close releases the handle. The storage is closed once every handle
+// for the database has been closed.
+// The database must not be used after it has been closed.
+func (p *Database) Close() error {
+	return p.factory.close(p)
 }
@@ -0,0 +1,246 @@
+package indexeddb
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// crashStorage keeps a copy of every file it writes so that a crash can be
+// simulated by dropping everything that was not synced.
+type crashStorage struct {
+	storage.Storage
+
+	mu    sync.Mutex
+	files map[storage.FileDesc]*crashFile
+}
+
+type crashFile struct {
+	data   []byte
+	synced int
+}
+
+func newCrashStorage() *crashStorage {
+	return &crashStorage{Storage: storage.NewMemStorage(), files: make(map[storage.FileDesc]*crashFile)}
+}
+
+func (s *crashStorage) Create(fd storage.FileDesc) (storage.Writer, error) {
+	w, err := s.Storage.Create(fd)
+	if err != nil {
+		return nil, err
+	}
+	f := &crashFile{}
+	s.mu.Lock()
+	s.files[fd] = f
+	s.mu.Unlock()
+	return &crashWriter{w, s, f}, nil
+}
+
+func (s *crashStorage) Remove(fd storage.FileDesc) error {
+	s.mu.Lock()
+	delete(s.files, fd)
+	s.mu.Unlock()
+	return s.Storage.Remove(fd)
+}
+
+func (s *crashStorage) Rename(oldfd, newfd storage.FileDesc) error {
+	err := s.Storage.Rename(oldfd, newfd)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.files[newfd] = s.files[oldfd]
+	delete(s.files, oldfd)
+	s.mu.Unlock()
+	return nil
+}
+
+// SetMeta marks the new manifest as synced. LevelDB relies on the storage
+// to persist it together with the pointer to it.
+func (s *crashStorage) SetMeta(fd storage.FileDesc) error {
+	err := s.Storage.SetMeta(fd)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if f, ok := s.files[fd]; ok {
+		f.synced = len(f.data)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// crash returns a new storage holding only the synced part of every file
+func (s *crashStorage) crash(t *testing.T) *crashStorage {
+	t.Helper()
+	out := newCrashStorage()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for fd, f := range s.files {
+		w, err := out.Create(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(f.data[:f.synced]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+	meta, err := s.GetMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.SetMeta(meta); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+type crashWriter struct {
+	storage.Writer
+	s *crashStorage
+	f *crashFile
+}
+
+func (w *crashWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.s.mu.Lock()
+	w.f.data = append(w.f.data, b[:n]...)
+	w.s.mu.Unlock()
+	return n, err
+}
+
+func (w *crashWriter) Sync() error {
+	err := w.Writer.Sync()
+	w.s.mu.Lock()
+	w.f.synced = len(w.f.data)
+	w.s.mu.Unlock()
+	return err
+}
+
+func TestDurability(t *testing.T) {
+	stor := newCrashStorage()
+	f := NewStorageFactory(func(string) (storage.Storage, error) { return stor, nil })
+
+	open := func(d TransactionDurability) *Database {
+		db, err := f.Open("test", 1).Durability(d).Migrate(func(_ uint, h *MigrationTransaction) error {
+			_, err := h.CreateStore("records", StoreOptions{})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	write := func(db *Database, d TransactionDurability, key string) {
+		tr, err := db.Transaction([]string{"records"}, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := must(tr.Store("records")).PutWithKey(Key{key}, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := tr.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Default resolves to the durability the database was opened with
+	db := open(Relaxed)
+	write(db, Strict, "strict")
+	write(db, Relaxed, "relaxed")
+	write(db, Default, "default")
+
+	crashed := stor.crash(t)
+	db.Close()
+	stor = crashed
+
+	db = open(Strict)
+
+	rt, err := db.ReadonlyTransaction([]string{"records"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := must(rt.Store("records"))
+	for key, survives := range map[string]bool{"strict": true, "relaxed": false, "default": false} {
+		var out string
+		err := s.GetExact(Key{key}, &out)
+		if survives && err != nil {
+			t.Errorf("%s write should survive a crash: %v", key, err)
+		}
+		if !survives && err == nil {
+			t.Errorf("%s write should be lost in a crash", key)
+		}
+	}
+	rt.Commit()
+
+	// a strict default syncs commits that ask for Default
+	write(db, Default, "synced")
+	crashed = stor.crash(t)
+	db.Close()
+	stor = crashed
+
+	db = open(Strict)
+	defer db.Close()
+	rt, err = db.ReadonlyTransaction([]string{"records"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+	var out string
+	if err := must(rt.Store("records")).GetExact(Key{"synced"}, &out); err != nil {
+		t.Errorf("default write on a strict database should survive a crash: %v", err)
+	}
+}
+
+func TestInvalidDurability(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{"records": {}})
+	if _, err := db.Transaction([]string{"records"}, "eventual"); err == nil {
+		t.Error("expected an error for an unknown durability")
+	}
+}
+
+func TestSpilledDurability(t *testing.T) {
+	stor := newCrashStorage()
+	f := NewStorageFactory(func(string) (storage.Storage, error) { return stor, nil })
+	db, err := f.Open("test", 1).Durability(Relaxed).Migrate(func(_ uint, h *MigrationTransaction) error {
+		_, err := h.CreateStore("records", StoreOptions{})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a write set beyond the buffer is committed as synced tables, even when relaxed
+	tr := must(db.Transaction([]string{"records"}, Relaxed))
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 5000; i++ {
+		if err := must(tr.Store("records")).PutWithKey(Key{float64(i)}, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	crashed := stor.crash(t)
+	db.Close()
+	stor = crashed
+
+	db, err = f.Open("test", 1).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rt := must(db.ReadonlyTransaction([]string{"records"}, Default))
+	defer rt.Commit()
+	if n, err := must(rt.Store("records")).Count(All()); err != nil || n != 5000 {
+		t.Errorf("expected the spilled commit to survive a crash, got %d records %v", n, err)
+	}
+}
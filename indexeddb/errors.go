@@ -0,0 +1,41 @@
+package indexeddb
+
+import (
+	"github.com/huffduff/go-indexeddb/indexeddb/internal"
+)
+
+// ErrorName is the kind of an error, named after the DOMException names used by IndexedDB
+type ErrorName = internal.ErrorName
+
+// Error is an error of a known kind. Use errors.As to read its Name,
+// or errors.Is with one of the sentinels below to test for a kind.
+type Error = internal.Error
+
+const (
+	NotFoundError            = internal.NotFoundError
+	ConstraintError          = internal.ConstraintError
+	DataError                = internal.DataError
+	ReadOnlyError            = internal.ReadOnlyError
+	TransactionInactiveError = internal.TransactionInactiveError
+	VersionError             = internal.VersionError
+	AbortError               = internal.AbortError
+	InvalidStateError        = internal.InvalidStateError
+	InvalidAccessError       = internal.InvalidAccessError
+)
+
+// Sentinels for errors.Is, each matches every error of its kind
+var (
+	ErrNotFound            = internal.ErrNotFound
+	ErrConstraint          = internal.ErrConstraint
+	ErrData                = internal.ErrData
+	ErrReadOnly            = internal.ErrReadOnly
+	ErrTransactionInactive = internal.ErrTransactionInactive
+	ErrVersion             = internal.ErrVersion
+	ErrAbort               = internal.ErrAbort
+	ErrInvalidState        = internal.ErrInvalidState
+	ErrInvalidAccess       = internal.ErrInvalidAccess
+)
+
+// ErrStop is returned by a ForEach callback to end the iteration early.
+// ForEach itself then returns nil.
+var ErrStop = internal.ErrStop
@@ -0,0 +1,74 @@
+package indexeddb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	path := t.TempDir()
+	db, err := Open("test", 2, path).Migrate(func(_ uint, h *MigrationTransaction) error {
+		_, err := h.CreateStore("records", StoreOptions{KeyPath: KeyPath{"_id"}})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tr, err := db.Transaction([]string{"records"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := must(tr.Store("records"))
+	if _, err := s.Add(testRecord{"a", "first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out testRecord
+	_, duplicate := s.Add(testRecord{"a", "again"})
+	_, invalid := s.Put(map[string]interface{}{"_id": true})
+	_, scope := tr.Store("other")
+	type errorCase struct {
+		err  error
+		kind *Error
+	}
+	tests := map[string]errorCase{
+		"missing record": {s.GetExact(Key{"b"}, &out), ErrNotFound},
+		"out of scope":   {scope, ErrNotFound},
+		"duplicate key":  {duplicate, ErrConstraint},
+		"invalid key":    {invalid, ErrData},
+	}
+	tr.Abort()
+
+	_, older := Open("test", 1, path).Migrate(noMigration)
+	tests["older version"] = errorCase{older, ErrVersion}
+
+	for name, test := range tests {
+		if !errors.Is(test.err, test.kind) {
+			t.Errorf("%s: expected a %s, got %v", name, test.kind.Name, test.err)
+			continue
+		}
+		var e *Error
+		if !errors.As(test.err, &e) || e.Name != test.kind.Name {
+			t.Errorf("%s: expected errors.As to find a %s", name, test.kind.Name)
+		}
+	}
+
+	if errors.Is(ErrNotFound, ErrConstraint) {
+		t.Error("errors of different kinds should not match")
+	}
+}
+
+func TestMigrationAbortError(t *testing.T) {
+	cause := errors.New("cause")
+	_, err := Open("test", 1, t.TempDir()).Migrate(func(_ uint, h *MigrationTransaction) error {
+		return cause
+	})
+	if !errors.Is(err, ErrAbort) {
+		t.Errorf("expected an abort error, got %v", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("abort error should wrap the migration error, got %v", err)
+	}
+}
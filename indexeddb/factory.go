@@ -2,42 +2,339 @@ package indexeddb
 
 import (
 	"bytes"
-	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/huffduff/go-indexeddb/indexeddb/internal"
+	"github.com/syndtr/goleveldb/leveldb/storage"
 )
 
+// Factory manages the databases stored under a single base directory
+type Factory struct {
+	path string
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	open map[string]*connection
+	// deleting counts the pending DeleteDatabaseWait calls per name, opens wait for them
+	deleting map[string]int
+
+	// storage keeps the databases in place of directories when set
+	storage func(name string) (storage.Storage, error)
+}
+
+// connection is the storage handle shared by every open Database of the same name
+type connection struct {
+	def *internal.Database
+
+	// refs counts open handles and migrations in progress
+	refs      int
+	handles   map[*Database]struct{}
+	upgrading bool
+
+	scheduler *scheduler
+}
+
+// attach creates a new handle for the connection. Callers must hold f.mu.
+func (p *connection) attach(f *Factory) *Database {
+	db := &Database{def: p.def, factory: f, conn: p}
+	p.handles[db] = struct{}{}
+	return db
+}
+
+// versionChangeCallbacks collects the callbacks of all open handles. Callers must hold f.mu.
+func (p *connection) versionChangeCallbacks() []func(oldVersion, newVersion uint) {
+	out := make([]func(oldVersion, newVersion uint), 0, len(p.handles))
+	for db := range p.handles {
+		if db.onVersionChange != nil {
+			out = append(out, db.onVersionChange)
+		}
+	}
+	return out
+}
+
+var factories = struct {
+	sync.Mutex
+	byPath map[string]*Factory
+}{byPath: make(map[string]*Factory)}
+
+// NewFactory returns the factory for the databases stored under path.
+// Factories are shared per directory so that every connection to a database
+// is tracked in one place.
+func NewFactory(path string) *Factory {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.Clean(path)
+
+	factories.Lock()
+	defer factories.Unlock()
+
+	if f, ok := factories.byPath[path]; ok {
+		return f
+	}
+	f := &Factory{path: path, open: make(map[string]*connection), deleting: make(map[string]int)}
+	f.cond = sync.NewCond(&f.mu)
+	factories.byPath[path] = f
+	return f
+}
+
+// NewStorageFactory returns a factory that keeps each database in the storage
+// returned by open instead of a directory, for example storage.NewMemStorage.
+// Storages are not closed with their databases. Without a directory to read,
+// Databases only lists open databases and DeleteDatabase is not supported.
+func NewStorageFactory(open func(name string) (storage.Storage, error)) *Factory {
+	f := &Factory{open: make(map[string]*connection), deleting: make(map[string]int), storage: open}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Path returns the base directory of the factory
+func (f *Factory) Path() string {
+	return f.path
+}
+
 // Open initializes a database and returns an initialization struct.
 // To get a database handle you must call the Migrate method of the returned value.
 // The Migrate function will only fire if the exisitng version is lower than the
 // requested version and no other database related errors have been triggered.
 // returning an error will rollback the migration and fail.
-func Open(name string, version uint, path string) *migrator {
-	def, err := internal.OpenDatabase(name, path)
-	if err != nil {
+// Connections to the same database share a single storage handle, which is only
+// opened by Migrate, so an Open that is never migrated holds nothing.
+func (f *Factory) Open(name string, version uint) *migrator {
+	if err := validName(name); err != nil {
 		return migrateError(err)
 	}
+	return &migrator{factory: f, name: name, version: version}
+}
 
-	if def.Version > version {
-		err = fmt.Errorf("existing database version %d > %d", def.Version, version)
-		return migrateError(err)
+// connect returns the connection to name, opening it if needed, and takes a
+// reference to it. It waits for pending deletes of the database first.
+func (f *Factory) connect(name string) (*connection, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for f.deleting[name] > 0 {
+		f.cond.Wait()
 	}
+	conn, ok := f.open[name]
+	if !ok {
+		def, err := f.openDatabase(name)
+		if err != nil {
+			return nil, err
+		}
+		err = def.Hydrate()
+		if err != nil {
+			def.Close()
+			return nil, err
+		}
+		conn = &connection{def: def, handles: make(map[*Database]struct{}), scheduler: newScheduler()}
+		f.open[name] = conn
+	}
+	conn.refs++
+	return conn, nil
+}
 
-	if def.Version < version {
-		return migrateRun(def, version)
+func (f *Factory) openDatabase(name string) (*internal.Database, error) {
+	if f.storage == nil {
+		return internal.OpenDatabase(name, f.path)
 	}
-	return migrateDone(def)
+	stor, err := f.storage(name)
+	if err != nil {
+		return nil, err
+	}
+	return internal.OpenStorage(name, stor)
 }
 
-func DeleteDatabase(name string) error {
-	return fmt.Errorf("not implemented")
+// release drops a reference to the connection, closing the storage handle
+// once nothing refers to it anymore
+func (f *Factory) release(conn *connection) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	conn.refs--
+	f.cond.Broadcast()
+	if conn.refs > 0 {
+		return nil
+	}
+	if f.open[conn.def.Name] == conn {
+		delete(f.open, conn.def.Name)
+	}
+	return conn.def.Close()
 }
 
-func Cmp(a []byte, b []byte) int {
-	return bytes.Compare(a, b)
+// close detaches a handle from its connection
+func (f *Factory) close(db *Database) error {
+	f.mu.Lock()
+	if _, ok := db.conn.handles[db]; !ok {
+		f.mu.Unlock()
+		return nil
+	}
+	delete(db.conn.handles, db)
+	f.mu.Unlock()
+
+	return f.release(db.conn)
+}
+
+// DeleteDatabase removes the named database from disk.
+// It fails while any connection to the database is open, in this process or another.
+func (f *Factory) DeleteDatabase(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.open[name]; ok {
+		return internal.NewError(InvalidStateError, "database %s has open connections", name)
+	}
+	return f.remove(name)
+}
+
+// DeleteDatabaseWait removes the named database from disk once every
+// open connection to it has been closed. Open connections receive a
+// versionchange notification with a new version of 0. Databases opened
+// meanwhile wait for the delete to finish.
+func (f *Factory) DeleteDatabaseWait(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.deleting[name]++
+	if conn, ok := f.open[name]; ok {
+		current := conn.def.Version
+		notify := conn.versionChangeCallbacks()
+		f.mu.Unlock()
+		for _, cb := range notify {
+			cb(current, 0)
+		}
+		f.mu.Lock()
+	}
+	defer f.mu.Unlock()
+	defer func() {
+		f.deleting[name]--
+		if f.deleting[name] == 0 {
+			delete(f.deleting, name)
+		}
+		f.cond.Broadcast()
+	}()
+
+	for {
+		if _, ok := f.open[name]; !ok {
+			break
+		}
+		f.cond.Wait()
+	}
+	return f.remove(name)
 }
 
+// remove deletes the database directory. Directories that do not hold a
+// LevelDB database are left untouched, as are databases another process has
+// open, which hold the LevelDB lock. Callers must hold f.mu.
+func (f *Factory) remove(name string) error {
+	if f.storage != nil {
+		return internal.NewError(InvalidStateError, "database %s is kept in storage that cannot be deleted", name)
+	}
+	dir := filepath.Join(f.path, name)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	if !isDatabase(dir) {
+		return internal.NewError(InvalidStateError, "%s is not a database", dir)
+	}
+	stor, err := storage.OpenFile(dir, false)
+	if err != nil {
+		return internal.NewError(InvalidStateError, "database %s is in use: %w", name, err)
+	}
+	err = stor.Close()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// Databases lists every database under the factory's directory with its version
+func (f *Factory) Databases() (map[string]uint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := make(map[string]uint)
+	if f.storage != nil {
+		for name, conn := range f.open {
+			out[name] = conn.def.Version
+		}
+		return out, nil
+	}
+
+	entries, err := os.ReadDir(f.path)
+	if os.IsNotExist(err) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !isDatabase(filepath.Join(f.path, name)) {
+			continue
+		}
+		// open databases hold the storage lock, use their in memory definition
+		if conn, ok := f.open[name]; ok {
+			out[name] = conn.def.Version
+			continue
+		}
+		def, err := internal.ReadDefinition(name, f.path)
+		if err != nil {
+			return nil, internal.NewError(InvalidStateError, "reading database %s: %w", name, err)
+		}
+		out[name] = def.Version
+	}
+	return out, nil
+}
+
+// isDatabase reports whether dir looks like a LevelDB database
+func isDatabase(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, "CURRENT"))
+	return err == nil && !info.IsDir()
+}
+
+// validName ensures a database name maps to a single directory below the factory path
+func validName(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return internal.NewError(DataError, "invalid database name %q", name)
+	}
+	return nil
+}
+
+// Open initializes a database under path, see Factory.Open
+func Open(name string, version uint, path string) *migrator {
+	return NewFactory(path).Open(name, version)
+}
+
+// defaultFactory serves the package level functions that take no path,
+// keeping their databases in the working directory
+func defaultFactory() *Factory {
+	return NewFactory(".")
+}
+
+// DeleteDatabase removes the named database from the working directory.
+//
+// Deprecated: use Factory.DeleteDatabase, which names the directory.
+func DeleteDatabase(name string) error {
+	return defaultFactory().DeleteDatabase(name)
+}
+
+// Databases lists the databases in the working directory with their versions.
+//
+// Deprecated: use Factory.Databases, which names the directory.
 func Databases() (map[string]uint, error) {
-	// will likely require a base path
-	return nil, fmt.Errorf("not implemented")
+	return defaultFactory().Databases()
+}
+
+func Cmp(a []byte, b []byte) int {
+	return bytes.Compare(a, b)
 }
@@ -0,0 +1,294 @@
+package indexeddb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func noMigration(_ uint, _ *MigrationTransaction) error {
+	return nil
+}
+
+func TestDatabases(t *testing.T) {
+	f := NewFactory(t.TempDir())
+
+	a, err := f.Open("a", 2).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := f.Open("b", 1).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	dbs, err := f.Databases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dbs, map[string]uint{"a": 2, "b": 1}) {
+		t.Errorf("unexpected databases %v", dbs)
+	}
+
+	// a directory that looks like a database but cannot be read
+	bad := filepath.Join(f.Path(), "bad")
+	if err := os.Mkdir(bad, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bad, "CURRENT"), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Databases(); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected an unreadable database to be an InvalidStateError, got %v", err)
+	}
+}
+
+func TestDeleteDatabase(t *testing.T) {
+	f := NewFactory(t.TempDir())
+
+	db, err := f.Open("a", 1).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.DeleteDatabase("a"); err == nil {
+		t.Error("expected deleting an open database to fail")
+	}
+	if err := f.DeleteDatabase("../a"); err == nil {
+		t.Error("expected an invalid name to be rejected")
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- f.DeleteDatabaseWait("a")
+	}()
+	select {
+	case <-done:
+		t.Fatal("delete should wait for the open connection")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// opens queue behind the pending delete instead of starving it
+	reopened := make(chan *Database)
+	go func() {
+		db, err := f.Open("a", 1).Migrate(noMigration)
+		if err != nil {
+			t.Error(err)
+		}
+		reopened <- db
+	}()
+	select {
+	case <-reopened:
+		t.Fatal("open should wait for the pending delete")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if db := <-reopened; db != nil {
+		db.Close()
+	}
+	if err := f.DeleteDatabase("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// an open that is never migrated holds no connection
+	f.Open("a", 1)
+	if err := f.DeleteDatabase("a"); err != nil {
+		t.Errorf("expected an unmigrated open not to block deleting, got %v", err)
+	}
+
+	dbs, err := f.Databases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dbs) != 0 {
+		t.Errorf("expected no databases, got %v", dbs)
+	}
+	if err := f.DeleteDatabase("a"); err != nil {
+		t.Errorf("deleting a missing database should succeed, got %s", err)
+	}
+}
+
+func TestDeleteDatabaseLocked(t *testing.T) {
+	f := NewFactory(t.TempDir())
+	db, err := f.Open("a", 1).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// another process holding the database open holds its lock
+	other, err := leveldb.OpenFile(filepath.Join(f.Path(), "a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.DeleteDatabase("a"); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected deleting a database open elsewhere to fail, got %v", err)
+	}
+	if dbs, err := f.Databases(); err == nil && len(dbs) != 1 {
+		t.Errorf("expected the database to be kept, got %v", dbs)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.DeleteDatabase("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(f.Path(), "a")); !os.IsNotExist(err) {
+		t.Errorf("expected the database to be removed, got %v", err)
+	}
+}
+
+func TestDefaultFactory(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	db, err := Open("a", 3, ".").Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if dbs, err := Databases(); err != nil || !reflect.DeepEqual(dbs, map[string]uint{"a": 3}) {
+		t.Errorf("expected the database in the working directory, got %v %v", dbs, err)
+	}
+	if err := DeleteDatabase("a"); err != nil {
+		t.Fatal(err)
+	}
+	if dbs, err := Databases(); err != nil || len(dbs) != 0 {
+		t.Errorf("expected the database to be deleted, got %v %v", dbs, err)
+	}
+}
+
+func TestSharedFactory(t *testing.T) {
+	path := t.TempDir()
+	if NewFactory(path) != NewFactory(path+"/.") {
+		t.Error("factories should be shared per directory")
+	}
+}
+
+func TestSharedConnections(t *testing.T) {
+	f := NewFactory(t.TempDir())
+
+	a, err := f.Open("db", 1).Migrate(func(_ uint, h *MigrationTransaction) error {
+		_, err := h.CreateStore("records", StoreOptions{})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := f.Open("db", 1).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("closing twice should be a no-op, got %s", err)
+	}
+
+	tr, err := b.Transaction([]string{"records"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := must(tr.Store("records")).PutWithKey(Key{"a"}, "still open"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionChange(t *testing.T) {
+	f := NewFactory(t.TempDir())
+
+	old, err := f.Open("db", 1).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan [2]uint, 1)
+	old.OnVersionChange(func(oldVersion, newVersion uint) {
+		changes <- [2]uint{oldVersion, newVersion}
+	})
+
+	blocked := make(chan [2]uint, 1)
+	done := make(chan error)
+	go func() {
+		db, err := f.Open("db", 2).OnBlocked(func(oldVersion, newVersion uint) {
+			blocked <- [2]uint{oldVersion, newVersion}
+		}).Migrate(noMigration)
+		if err == nil {
+			defer db.Close()
+			if db.Version() != 2 {
+				t.Errorf("expected version 2, got %d", db.Version())
+			}
+		}
+		done <- err
+	}()
+
+	if change := <-changes; change != [2]uint{1, 2} {
+		t.Errorf("unexpected versionchange %v", change)
+	}
+	if change := <-blocked; change != [2]uint{1, 2} {
+		t.Errorf("unexpected blocked %v", change)
+	}
+	select {
+	case <-done:
+		t.Fatal("upgrade should wait for the old connection to close")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := old.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionChangeClose(t *testing.T) {
+	f := NewFactory(t.TempDir())
+
+	old, err := f.Open("db", 1).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.OnVersionChange(func(_, _ uint) {
+		old.Close()
+	})
+
+	db, err := f.Open("db", 2).OnBlocked(func(_, _ uint) {
+		t.Error("closing on versionchange should not block the upgrade")
+	}).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := f.Open("db", 1).Migrate(noMigration); err == nil {
+		t.Error("expected opening an older version to fail")
+	}
+}
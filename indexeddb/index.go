@@ -1,6 +1,9 @@
 package indexeddb
 
 import (
+	"context"
+	"reflect"
+
 	"github.com/huffduff/go-indexeddb/indexeddb/internal"
 	"github.com/syndtr/goleveldb/leveldb"
 )
@@ -8,15 +11,50 @@ import (
 type Cursor = internal.Cursor
 type KeyCursor = internal.KeyCursor
 type MultiKeyCursor = internal.MultiKeyCursor
+type MultiCursor = internal.MultiCursor
 
 type Direction = internal.Direction
 
+const (
+	Next       Direction = internal.NEXT
+	Prev       Direction = internal.PREV
+	NextUnique Direction = internal.NEXTUNIQUE
+	PrevUnique Direction = internal.PREVUNIQUE
+)
+
 type IndexOptions struct {
-	KeyPath    string
+	KeyPath    KeyPath
 	Unique     bool
 	MultiEntry bool
 }
 
+// IndexSpec is a named index definition
+type IndexSpec struct {
+	Name    string
+	Options IndexOptions
+}
+
+// IndexesOf derives index definitions from the idb struct tags of v.
+// A tag names the index a field belongs to, optionally followed by options:
+//
+//	Email string   `json:"email" idb:"byEmail,unique"`
+//	Tags  []string `json:"tags" idb:"byTag,multi"`
+//
+// The key path is the field's json name, dotted for fields of nested structs.
+// Fields tagged with the same index name form a compound key path in field order.
+// Separate several indexes on one field with semicolons.
+func IndexesOf(v interface{}) ([]IndexSpec, error) {
+	defs, err := internal.IndexesOf(reflect.TypeOf(v))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]IndexSpec, len(defs))
+	for i, def := range defs {
+		out[i] = IndexSpec{def.Name, IndexOptions{def.KeyPath, def.Unique, def.MultiEntry}}
+	}
+	return out, nil
+}
+
 type Indexer = internal.Indexer
 
 type Index struct {
@@ -24,7 +62,8 @@ type Index struct {
 
 	Store ReadStore
 
-	h leveldb.Reader
+	h  leveldb.Reader
+	tr *baseTransaction
 
 	// Key *func(record interface{}) []byte
 	// unique bool
@@ -36,7 +75,7 @@ func (p *Index) Name() string {
 }
 
 // are these 3 necessary?
-func (p *Index) KeyPath() string {
+func (p *Index) KeyPath() KeyPath {
 	return p.def.KeyPath
 }
 
@@ -49,51 +88,177 @@ func (p *Index) Unique() bool {
 }
 
 func (p *Index) GetExact(key Key, v interface{}) error {
-	primaryKey, err := p.def.GetExact(p.h, key)
-	if err != nil {
-		return err
-	}
-	return p.Store.GetExact(primaryKey, v)
+	return p.GetExactContext(context.Background(), key, v)
+}
+
+func (p *Index) GetExactContext(ctx context.Context, key Key, v interface{}) error {
+	return p.tr.run(ctx, func() error {
+		primaryKey, err := p.def.GetExact(p.h, key)
+		if err != nil {
+			return err
+		}
+		return p.Store.GetExact(primaryKey, v)
+	})
 }
 
 func (p *Index) Get(query Range, v interface{}) error {
-	primaryKey, err := p.def.Get(p.h, query)
-	if err != nil {
-		return err
-	}
+	return p.GetContext(context.Background(), query, v)
+}
+
+func (p *Index) GetContext(ctx context.Context, query Range, v interface{}) error {
+	return p.tr.run(ctx, func() error {
+		primaryKey, err := p.def.Get(p.h, query)
+		if err != nil {
+			return err
+		}
 
-	return p.Store.GetExact(primaryKey, v)
+		return p.Store.GetExact(primaryKey, v)
+	})
 }
 
 func (p *Index) GetAll(query Range, limit int, v interface{}) error {
-	refs, err := p.def.GetAll(p.h, query, limit)
-	if err != nil {
-		return err
-	}
+	return p.GetAllContext(context.Background(), query, limit, v)
+}
 
-	return p.Store.GetMulti(refs, v)
+func (p *Index) GetAllContext(ctx context.Context, query Range, limit int, v interface{}) error {
+	return p.tr.run(ctx, func() error {
+		refs, err := p.def.GetAll(p.h, query, limit)
+		if err != nil {
+			return err
+		}
+
+		return p.Store.GetMulti(refs, v)
+	})
 }
 
 func (p *Index) GetExactKey(key Key) (Key, error) {
-	return p.def.GetExact(p.h, key)
+	return p.GetExactKeyContext(context.Background(), key)
+}
+
+func (p *Index) GetExactKeyContext(ctx context.Context, key Key) (Key, error) {
+	var out Key
+	err := p.tr.run(ctx, func() (err error) {
+		out, err = p.def.GetExact(p.h, key)
+		return err
+	})
+	return out, err
 }
 
 func (p *Index) GetKey(query Range) (Key, error) {
-	return p.def.Get(p.h, query)
+	return p.GetKeyContext(context.Background(), query)
+}
+
+func (p *Index) GetKeyContext(ctx context.Context, query Range) (Key, error) {
+	var out Key
+	err := p.tr.run(ctx, func() (err error) {
+		out, err = p.def.Get(p.h, query)
+		return err
+	})
+	return out, err
 }
 
 func (p *Index) GetAllKeys(query Range, limit int) ([]Key, error) {
-	return p.def.GetAll(p.h, query, limit)
+	return p.GetAllKeysContext(context.Background(), query, limit)
+}
+
+func (p *Index) GetAllKeysContext(ctx context.Context, query Range, limit int) ([]Key, error) {
+	var out []Key
+	err := p.tr.run(ctx, func() (err error) {
+		out, err = p.def.GetAll(p.h, query, limit)
+		return err
+	})
+	return out, err
 }
 
 func (p *Index) Count(query Range) (uint, error) {
-	return p.def.Count(p.h, query)
+	return p.CountContext(context.Background(), query)
+}
+
+func (p *Index) CountContext(ctx context.Context, query Range) (uint, error) {
+	var out uint
+	err := p.tr.run(ctx, func() (err error) {
+		out, err = p.def.Count(p.h, query)
+		return err
+	})
+	return out, err
+}
+
+// ForEach calls fn with the primary key and a decoder for every record referenced
+// within query, in index key order, reading one record at a time.
+// Returning ErrStop from fn ends the iteration early without an error.
+func (p *Index) ForEach(query Range, fn func(primaryKey Key, value Decoder) error) error {
+	return p.ForEachContext(context.Background(), query, fn)
+}
+
+func (p *Index) ForEachContext(ctx context.Context, query Range, fn func(primaryKey Key, value Decoder) error) error {
+	return p.tr.run(ctx, func() error {
+		return p.def.ForEach(p.h, query, fn)
+	})
+}
+
+// GetAllRecords returns the index key, primary key and value of the records selected by opts,
+// in either direction and starting after an offset
+func (p *Index) GetAllRecords(opts GetAllOptions) ([]Entry, error) {
+	return p.GetAllRecordsContext(context.Background(), opts)
+}
+
+func (p *Index) GetAllRecordsContext(ctx context.Context, opts GetAllOptions) ([]Entry, error) {
+	var out []Entry
+	err := p.tr.run(ctx, func() (err error) {
+		out, err = p.def.GetAllRecords(p.h, opts)
+		return err
+	})
+	return out, err
 }
 
-func (p *Index) OpenCursor(query Range, dir Direction) (MultiKeyCursor, error) {
-	return p.def.GetCursor(p.h, query, dir)
+// OpenCursor iterates the index, exposing the index key, the primary key and the referenced record
+func (p *Index) OpenCursor(query Range, dir Direction) (MultiCursor, error) {
+	return p.OpenCursorContext(context.Background(), query, dir)
 }
 
-func (p *Index) OpenKeyCursor(query Range, dir Direction) (KeyCursor, error) {
-	return p.def.GetCursor(p.h, query, dir)
+// OpenCursorContext opens a cursor that stops iterating once ctx is done
+func (p *Index) OpenCursorContext(ctx context.Context, query Range, dir Direction) (MultiCursor, error) {
+	var out MultiCursor
+	err := p.tr.run(ctx, func() (err error) {
+		out, err = p.def.GetCursor(p.h, query, dir)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ResumeCursor opens a cursor from a token made by a cursor of this index, possibly in an
+// earlier transaction. Its first Continue moves to the entry after the token's.
+func (p *Index) ResumeCursor(token string) (MultiCursor, error) {
+	return p.ResumeCursorContext(context.Background(), token)
+}
+
+func (p *Index) ResumeCursorContext(ctx context.Context, token string) (MultiCursor, error) {
+	var out MultiCursor
+	err := p.tr.run(ctx, func() (err error) {
+		out, err = p.def.ResumeCursor(p.h, token)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (p *Index) OpenKeyCursor(query Range, dir Direction) (MultiKeyCursor, error) {
+	return p.OpenKeyCursorContext(context.Background(), query, dir)
+}
+
+func (p *Index) OpenKeyCursorContext(ctx context.Context, query Range, dir Direction) (MultiKeyCursor, error) {
+	var out MultiKeyCursor
+	err := p.tr.run(ctx, func() (err error) {
+		out, err = p.def.GetCursor(p.h, query, dir)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
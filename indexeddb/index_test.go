@@ -0,0 +1,253 @@
+package indexeddb
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testUser struct {
+	Id    string `json:"_id"`
+	Email string `json:"email"`
+}
+
+func TestUniqueIndex(t *testing.T) {
+	db, err := Open("users", 1, t.TempDir()).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.CreateStore("users", StoreOptions{KeyPath: KeyPath{"_id"}})
+		if err != nil {
+			return err
+		}
+		return s.CreateIndex("byEmail", IndexOptions{KeyPath: KeyPath{"email"}, Unique: true})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tr, err := db.Transaction([]string{"users"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+	s := must(tr.Store("users"))
+
+	if _, err := s.Put(testUser{"1", "a@x"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(testUser{"2", "a@x"}); !errors.Is(err, ErrConstraint) {
+		t.Errorf("expected a constraint error for a taken email, got %v", err)
+	}
+	if _, err := s.Put(testUser{"1", "a@x"}); err != nil {
+		t.Errorf("a record should be able to keep its own unique key, got %v", err)
+	}
+
+	// a released key can be taken by another record
+	if _, err := s.Put(testUser{"1", "b@x"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(testUser{"2", "a@x"}); err != nil {
+		t.Errorf("expected the released email to be available, got %v", err)
+	}
+	if key, err := must(s.Index("byEmail")).GetKey(Only(Key{"b@x"})); err != nil || key[0] != "1" {
+		t.Errorf("the failed write should not change the index, got %v %v", key, err)
+	}
+	if idx, err := s.Index("missing"); !errors.Is(err, ErrNotFound) || idx != nil {
+		t.Errorf("expected an unknown index to be not found, got %v %v", idx, err)
+	}
+
+	c, err := s.OpenCursor(Only(Key{"2"}), Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Continue() {
+		t.Fatal("expected a record")
+	}
+	if err := c.Update(testUser{"2", "b@x"}); !errors.Is(err, ErrConstraint) {
+		t.Errorf("expected cursor updates to be checked, got %v", err)
+	}
+
+	_, err = s.BulkPut([]KeyValue{{Value: testUser{"3", "c@x"}}, {Value: testUser{"4", "c@x"}}}, BulkOptions{})
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) || len(bulkErr.Errors) != 1 || !errors.Is(bulkErr.Errors[1], ErrConstraint) {
+		t.Errorf("expected the second item of the batch to fail, got %v", err)
+	}
+	if n, _ := must(s.Index("byEmail")).Count(All()); n != 3 {
+		t.Errorf("expected 3 index entries, got %d", n)
+	}
+}
+
+type testEvent struct {
+	Tenant  string `json:"tenant"`
+	Id      int    `json:"id"`
+	Created int    `json:"created"`
+	Author  struct {
+		Id string `json:"id"`
+	} `json:"author"`
+}
+
+func TestCompoundKeyPath(t *testing.T) {
+	db, err := Open("events", 1, t.TempDir()).Migrate(func(_ uint, h *MigrationTransaction) error {
+		if _, err := h.CreateStore("bad", StoreOptions{KeyPath: KeyPath{"a", "b"}, AutoIncrement: true}); !errors.Is(err, ErrInvalidAccess) {
+			t.Errorf("expected a compound key path with a key generator to fail, got %v", err)
+		}
+		s, err := h.CreateStore("events", StoreOptions{KeyPath: KeyPath{"tenant", "id"}})
+		if err != nil {
+			return err
+		}
+		if err := s.CreateIndex("bad", IndexOptions{KeyPath: KeyPath{"a", "b"}, MultiEntry: true}); !errors.Is(err, ErrInvalidAccess) {
+			t.Errorf("expected a compound multiEntry index to fail, got %v", err)
+		}
+		if err := s.CreateIndex("byTenantCreated", IndexOptions{KeyPath: KeyPath{"tenant", "created"}}); err != nil {
+			return err
+		}
+		return s.CreateIndex("byAuthor", IndexOptions{KeyPath: KeyPath{"author.id"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tr, err := db.Transaction([]string{"events"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+	s := must(tr.Store("events"))
+
+	for i, tenant := range []string{"a", "b", "a", "a"} {
+		e := testEvent{Tenant: tenant, Id: i, Created: 10 - i}
+		e.Author.Id = tenant + "-author"
+		key, err := s.Put(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(key, Key{tenant, float64(i)}) {
+			t.Errorf("expected a compound primary key, got %v", key)
+		}
+	}
+
+	var out testEvent
+	if err := s.GetExact(Key{"b", 1.0}, &out); err != nil || out.Tenant != "b" {
+		t.Errorf("expected to get by compound key, got %v %v", out, err)
+	}
+	if n, err := s.Count(Prefix(Key{"a"})); err != nil || n != 3 {
+		t.Errorf("expected 3 records for tenant a, got %d %v", n, err)
+	}
+
+	idx := must(s.Index("byTenantCreated"))
+	if key, err := idx.GetKey(Prefix(Key{"a"})); err != nil || !reflect.DeepEqual(key, Key{"a", 3.0}) {
+		t.Errorf("expected the earliest event of tenant a, got %v %v", key, err)
+	}
+	if n, err := idx.Count(Bound(Key{"a", 8.0}, Key{"a", 10.0}, false, false)); err != nil || n != 2 {
+		t.Errorf("expected 2 events in the created range, got %d %v", n, err)
+	}
+	if n, err := must(s.Index("byAuthor")).Count(Only(Key{"a-author"})); err != nil || n != 3 {
+		t.Errorf("expected the nested key path to be indexed, got %d %v", n, err)
+	}
+}
+
+func TestSchemalessIndex(t *testing.T) {
+	db, err := Open("docs", 1, t.TempDir()).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.CreateStore("docs", StoreOptions{KeyPath: KeyPath{"id"}})
+		if err != nil {
+			return err
+		}
+		return s.CreateIndex("byKind", IndexOptions{KeyPath: KeyPath{"meta.kind"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tr, err := db.Transaction([]string{"docs"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+	s := must(tr.Store("docs"))
+
+	for _, doc := range []interface{}{
+		json.RawMessage(`{"id":"a","meta":{"kind":"note"}}`),
+		map[string]interface{}{"id": "b", "meta": map[string]string{"kind": "note"}},
+		json.RawMessage(`{"id":"c"}`),
+	} {
+		if _, err := s.Put(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := must(s.Index("byKind")).Count(Only(Key{"note"})); err != nil || n != 2 {
+		t.Errorf("expected 2 notes, got %d %v", n, err)
+	}
+	if n, err := s.Count(All()); err != nil || n != 3 {
+		t.Errorf("records without the key path should still be stored, got %d %v", n, err)
+	}
+}
+
+func TestGetAllRecords(t *testing.T) {
+	db := openTaskDatabase(t)
+
+	rt, err := db.ReadonlyTransaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+	s := must(rt.Store("tasks"))
+
+	primaryKeys := func(entries []Entry) []Key {
+		out := make([]Key, len(entries))
+		for i, e := range entries {
+			out[i] = e.PrimaryKey
+		}
+		return out
+	}
+
+	entries, err := s.GetAllRecords(GetAllOptions{Query: All(), Direction: Prev, Offset: 1, Count: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := primaryKeys(entries); !reflect.DeepEqual(keys, []Key{{"c"}, {"b"}}) {
+		t.Errorf("expected [c b], got %v", keys)
+	}
+	var task testTask
+	if err := entries[0].Value(&task); err != nil || task != (testTask{"c", "stale"}) {
+		t.Errorf("unexpected value %v %v", task, err)
+	}
+	if entries, err := s.GetAllRecords(GetAllOptions{Offset: 4}); err != nil || len(entries) != 0 {
+		t.Errorf("expected no records past the end, got %v %v", entries, err)
+	}
+	if _, err := s.GetAllRecords(GetAllOptions{Count: -1}); !errors.Is(err, ErrData) {
+		t.Errorf("expected a negative count to fail, got %v", err)
+	}
+
+	idx := must(s.Index("byStatus"))
+	entries, err = idx.GetAllRecords(GetAllOptions{Direction: Prev})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := primaryKeys(entries); !reflect.DeepEqual(keys, []Key{{"c"}, {"d"}, {"b"}, {"a"}}) {
+		t.Errorf("expected [c d b a], got %v", keys)
+	}
+	if !reflect.DeepEqual(entries[1].Key, Key{"open"}) {
+		t.Errorf("expected the index key, got %v", entries[1].Key)
+	}
+	entries, err = idx.GetAllRecords(GetAllOptions{Direction: NextUnique})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := primaryKeys(entries); !reflect.DeepEqual(keys, []Key{{"a"}, {"c"}}) {
+		t.Errorf("expected [a c], got %v", keys)
+	}
+
+	if keys, err := s.GetAllKeys(All(), 2); err != nil || !reflect.DeepEqual(keys, []Key{{"a"}, {"b"}}) {
+		t.Errorf("expected GetAllKeys to stop at its limit, got %v %v", keys, err)
+	}
+	var open []testTask
+	if err := idx.GetAll(Only(Key{"open"}), 2, &open); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(open, []testTask{{"a", "open"}, {"b", "open"}}) {
+		t.Errorf("expected the first two open tasks, got %v", open)
+	}
+}
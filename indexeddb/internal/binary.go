@@ -0,0 +1,259 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+)
+
+// tags of the binary codec
+const (
+	binNull byte = iota
+	binFalse
+	binTrue
+	binInt
+	binFloat
+	binString
+	binArray
+	binObject
+)
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+// Marshal encodes the JSON form of v, so its fields are named by the same
+// rules as with the JSON codec. The JSON is tokenised into the binary format.
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, NewError(DataError, "binary codec cannot encode %T: %w", v, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// kept as number literals so integers are not rounded through float64
+	dec.UseNumber()
+	var buf bytes.Buffer
+	err = encodeTokens(&buf, dec)
+	return buf.Bytes(), err
+}
+
+// Unmarshal decodes into v like json.Unmarshal would decode the JSON form of the value
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	var buf bytes.Buffer
+	err := decodeBinary(&buf, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(buf.Bytes(), v)
+	if err != nil {
+		return NewError(DataError, "binary codec cannot decode into %T: %w", v, err)
+	}
+	return nil
+}
+
+// encodeTokens writes the next JSON value of dec in the binary format
+func encodeTokens(buf *bytes.Buffer, dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case nil:
+		buf.WriteByte(binNull)
+	case bool:
+		if t {
+			buf.WriteByte(binTrue)
+		} else {
+			buf.WriteByte(binFalse)
+		}
+	case json.Number:
+		// integral numbers are stored as integers, others as floats
+		if i, err := t.Int64(); err == nil {
+			writeBinaryInt(buf, i)
+			return nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return err
+		}
+		writeBinaryFloat(buf, f)
+	case string:
+		buf.WriteByte(binString)
+		writeBinaryString(buf, t)
+	case json.Delim:
+		tag := binArray
+		if t == '{' {
+			tag = binObject
+		}
+		// the length comes first, so the elements are encoded aside
+		var elems bytes.Buffer
+		n := 0
+		for ; dec.More(); n++ {
+			if tag == binObject {
+				name, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				writeBinaryString(&elems, name.(string))
+			}
+			err := encodeTokens(&elems, dec)
+			if err != nil {
+				return err
+			}
+		}
+		// the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		buf.WriteByte(tag)
+		writeBinaryLength(buf, n)
+		buf.Write(elems.Bytes())
+	}
+	return nil
+}
+
+func writeBinaryLength(buf *bytes.Buffer, length int) {
+	var n [binary.MaxVarintLen64]byte
+	buf.Write(n[:binary.PutUvarint(n[:], uint64(length))])
+}
+
+func writeBinaryString(buf *bytes.Buffer, s string) {
+	writeBinaryLength(buf, len(s))
+	buf.WriteString(s)
+}
+
+func writeBinaryInt(buf *bytes.Buffer, i int64) {
+	buf.WriteByte(binInt)
+	var n [binary.MaxVarintLen64]byte
+	buf.Write(n[:binary.PutVarint(n[:], i)])
+}
+
+func writeBinaryFloat(buf *bytes.Buffer, f float64) {
+	buf.WriteByte(binFloat)
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], math.Float64bits(f))
+	buf.Write(n[:])
+}
+
+func readBinaryString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > uint64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	out := make([]byte, n)
+	_, err = io.ReadFull(r, out)
+	return string(out), err
+}
+
+// readBinaryLength reads the length of an array or object, which has at least
+// a byte left for each element
+func readBinaryLength(r *bytes.Reader) (uint64, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+// writeJSON writes v with encoding/json, for strings and floats
+func writeJSON(buf *bytes.Buffer, v interface{}) error {
+	data, err := json.Marshal(v)
+	buf.Write(data)
+	return err
+}
+
+// decodeBinary writes the JSON form of the next value of r
+func decodeBinary(buf *bytes.Buffer, r *bytes.Reader) error {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch tag {
+	case binNull:
+		buf.WriteString("null")
+	case binFalse:
+		buf.WriteString("false")
+	case binTrue:
+		buf.WriteString("true")
+	case binInt:
+		i, err := binary.ReadVarint(r)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(strconv.FormatInt(i, 10))
+	case binFloat:
+		var bits uint64
+		err := binary.Read(r, binary.BigEndian, &bits)
+		if err != nil {
+			return err
+		}
+		f := math.Float64frombits(bits)
+		if f == math.Trunc(f) && math.Abs(f) < 1e21 {
+			// integers beyond int64 are written in full, so they decode into uint64
+			buf.WriteString(strconv.FormatFloat(f, 'f', 0, 64))
+			return nil
+		}
+		return writeJSON(buf, f)
+	case binString:
+		s, err := readBinaryString(r)
+		if err != nil {
+			return err
+		}
+		return writeJSON(buf, s)
+	case binArray:
+		n, err := readBinaryLength(r)
+		if err != nil {
+			return err
+		}
+		buf.WriteByte('[')
+		for i := uint64(0); i < n; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := decodeBinary(buf, r)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case binObject:
+		n, err := readBinaryLength(r)
+		if err != nil {
+			return err
+		}
+		buf.WriteByte('{')
+		for i := uint64(0); i < n; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			name, err := readBinaryString(r)
+			if err != nil {
+				return err
+			}
+			err = writeJSON(buf, name)
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			err = decodeBinary(buf, r)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return NewError(DataError, "binary codec found unknown tag %d", tag)
+	}
+	return nil
+}
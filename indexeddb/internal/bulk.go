@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// KeyValue is one record of a bulk write. Without a key the store's key path
+// or key generator provides one.
+type KeyValue struct {
+	Key   Key
+	Value interface{}
+}
+
+// BulkError lists the items of a bulk operation that failed, by position
+type BulkError struct {
+	Errors map[int]error
+	// Atomic is set when no item was written because some failed
+	Atomic bool
+}
+
+func (e *BulkError) Error() string {
+	first := e.indexes()[0]
+	return fmt.Sprintf("%d bulk items failed, item %d: %v", len(e.Errors), first, e.Errors[first])
+}
+
+// Is reports whether any item error matches target
+func (e *BulkError) Is(target error) bool {
+	for _, i := range e.indexes() {
+		if errors.Is(e.Errors[i], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first item error, in item order, that matches target
+func (e *BulkError) As(target interface{}) bool {
+	for _, i := range e.indexes() {
+		if errors.As(e.Errors[i], target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *BulkError) indexes() []int {
+	out := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		out = append(out, i)
+	}
+	sort.Ints(out)
+	return out
+}
+
+// bulkWriter collects the writes of one or more items in a single batch.
+// Records and index entries written by earlier items are visible to later ones.
+type bulkWriter struct {
+	store   *Store
+	tr      *Transaction
+	b       *leveldb.Batch
+	pending map[string][]byte
+}
+
+func (w *bulkWriter) set(key, value []byte) {
+	w.b.Put(key, value)
+	w.pending[string(key)] = value
+}
+
+func (w *bulkWriter) remove(key []byte) {
+	w.b.Delete(key)
+	w.pending[string(key)] = nil
+}
+
+func (p *Store) newBulkWriter(tr *Transaction) *bulkWriter {
+	return &bulkWriter{p, tr, &leveldb.Batch{}, make(map[string][]byte)}
+}
+
+// get reads a key, including the writes made earlier in the batch
+func (w *bulkWriter) get(primaryKey []byte) ([]byte, error) {
+	if data, ok := w.pending[string(primaryKey)]; ok {
+		if data == nil {
+			return nil, leveldb.ErrNotFound
+		}
+		return data, nil
+	}
+	return w.tr.Get(primaryKey, nil)
+}
+
+// put stores value under key, failing if add is set and the key is taken
+func (w *bulkWriter) put(key Key, value interface{}, add bool) error {
+	primaryKey, err := key.forStore(w.store)
+	if err != nil {
+		return err
+	}
+
+	data, err := w.get(primaryKey)
+	exists := err == nil
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return err
+	}
+	if add && exists {
+		return NewError(ConstraintError, "record already exists")
+	}
+
+	var existingIdx map[string][][]byte
+	if exists {
+		record, err := unmarshalRecord(data)
+		if err != nil {
+			return err
+		}
+		existingIdx = record.IndexKeys
+	}
+
+	next, err := w.store.nextNumber(w, key)
+	if err != nil {
+		return err
+	}
+
+	err = w.store.put(w, key, primaryKey, existingIdx, value)
+	if err != nil {
+		return err
+	}
+	// the generator only moves once the record is part of the batch
+	if next > 0 {
+		w.store.setCurrentNumber(w, next)
+	}
+	return nil
+}
+
+// delete removes the record stored under key, if any
+func (w *bulkWriter) delete(key Key) error {
+	primaryKey, err := key.forStore(w.store)
+	if err != nil {
+		return err
+	}
+
+	data, err := w.get(primaryKey)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return w.deleteRecord(primaryKey, data)
+}
+
+// deleteRecord removes a stored record and its index entries
+func (w *bulkWriter) deleteRecord(primaryKey []byte, data []byte) error {
+	record, err := unmarshalRecord(data)
+	if err != nil {
+		return err
+	}
+
+	for _, keys := range record.IndexKeys {
+		for _, key := range keys {
+			w.remove(key)
+		}
+	}
+	w.remove(primaryKey)
+	return nil
+}
+
+func (w *bulkWriter) flush() error {
+	if w.b.Len() == 0 {
+		return nil
+	}
+	return w.tr.Write(w.b, nil)
+}
+
+// bulk runs fn for every item and writes the successful ones in one batch.
+// When atomic is set nothing is written if any item fails.
+func (p *Store) bulk(tr *Transaction, n int, atomic bool, fn func(w *bulkWriter, i int) error) error {
+	w := p.newBulkWriter(tr)
+	failed := make(map[int]error)
+	for i := 0; i < n; i++ {
+		err := fn(w, i)
+		if err != nil {
+			failed[i] = err
+		}
+	}
+	if len(failed) > 0 && atomic {
+		return &BulkError{failed, true}
+	}
+	err := w.flush()
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &BulkError{failed, false}
+	}
+	return nil
+}
+
+// BulkPut stores every item and returns their keys. Keys of failed items are nil.
+// Failed items do not advance the key generator.
+func (p *Store) BulkPut(tr *Transaction, items []KeyValue, atomic bool) ([]Key, error) {
+	return p.bulkPut(tr, items, false, atomic)
+}
+
+// BulkAdd stores every item like BulkPut, failing the items whose key is already taken
+func (p *Store) BulkAdd(tr *Transaction, items []KeyValue, atomic bool) ([]Key, error) {
+	return p.bulkPut(tr, items, true, atomic)
+}
+
+func (p *Store) bulkPut(tr *Transaction, items []KeyValue, add bool, atomic bool) ([]Key, error) {
+	keys := make([]Key, len(items))
+	err := p.bulk(tr, len(items), atomic, func(w *bulkWriter, i int) error {
+		key := items[i].Key
+		if key == nil {
+			var err error
+			key, err = p.keyForValue(w, items[i].Value)
+			if err != nil {
+				return err
+			}
+		}
+		err := w.put(key, items[i].Value, add)
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+		return nil
+	})
+	if bulkErr, ok := err.(*BulkError); ok && !bulkErr.Atomic {
+		return keys, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// BulkDelete removes the records stored under keys
+func (p *Store) BulkDelete(tr *Transaction, keys []Key, atomic bool) error {
+	return p.bulk(tr, len(keys), atomic, func(w *bulkWriter, i int) error {
+		return w.delete(keys[i])
+	})
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"sync"
+)
+
+// Codec encodes the values kept in a store. Its name is recorded in the store
+// spec, so a codec must keep its name and format once data has been stored.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec stores values with encoding/json. It is used by stores without a codec.
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec stores values with encoding/gob. Gob cannot decode into an empty
+	// interface, so indexes can only be created on its stores before they hold
+	// records. Use NewGobCodec to index existing records.
+	GobCodec Codec = gobCodec{}
+	// BinaryCodec stores the JSON form of values in a compact tagged binary format
+	BinaryCodec Codec = binaryCodec{}
+)
+
+var codecs = struct {
+	sync.RWMutex
+	byName map[string]Codec
+}{byName: map[string]Codec{
+	JSONCodec.Name():   JSONCodec,
+	GobCodec.Name():    GobCodec,
+	BinaryCodec.Name(): BinaryCodec,
+}}
+
+// RegisterCodec makes a codec available to stores that recorded its name.
+// Registering an equal codec again is allowed, registering a different codec
+// under a taken name fails, as records would decode through the wrong codec.
+func RegisterCodec(c Codec) error {
+	codecs.Lock()
+	defer codecs.Unlock()
+	existing, ok := codecs.byName[c.Name()]
+	if !ok {
+		codecs.byName[c.Name()] = c
+		return nil
+	}
+	if !sameCodec(existing, c) {
+		return NewError(ConstraintError, "codec %s is already registered", c.Name())
+	}
+	return nil
+}
+
+// sameCodec reports whether two codecs are interchangeable, comparing their
+// types and values so typed codecs with different prototypes differ
+func sameCodec(a, b Codec) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	if reflect.TypeOf(a).Comparable() {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// LookupCodec finds a registered codec. An empty name is the JSON codec.
+func LookupCodec(name string) (Codec, error) {
+	if name == "" {
+		return JSONCodec, nil
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.byName[name]
+	if !ok {
+		return nil, NewError(NotFoundError, "codec %s is not registered", name)
+	}
+	return c, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// TypedCodec is implemented by codecs that cannot decode into an empty interface.
+// New returns a pointer to a new value of the stored type, which records are
+// decoded into when an index is built over them.
+type TypedCodec interface {
+	Codec
+	New() interface{}
+}
+
+// NewGobCodec returns a gob codec named name for values of the type of prototype.
+// Unlike GobCodec, indexes can be created on its stores once they hold records.
+func NewGobCodec(name string, prototype interface{}) TypedCodec {
+	return typedGobCodec{gobCodec{}, name, reflect.TypeOf(prototype)}
+}
+
+type typedGobCodec struct {
+	gobCodec
+	name string
+	t    reflect.Type
+}
+
+func (p typedGobCodec) Name() string {
+	return p.name
+}
+
+func (p typedGobCodec) New() interface{} {
+	return reflect.New(p.t).Interface()
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type codecValue struct {
+	Name  string                 `json:"name"`
+	Count int64                  `json:"count"`
+	Ratio float64                `json:"ratio"`
+	Tags  []string               `json:"tags"`
+	Extra map[string]interface{} `json:"extra"`
+	Ok    bool                   `json:"ok"`
+	Ptr   *string                `json:"ptr"`
+}
+
+func TestCodecs(t *testing.T) {
+	in := codecValue{"a", 1 << 60, 2.5, []string{"x", "y"}, map[string]interface{}{"n": -3.0, "s": "t"}, true, nil}
+	for _, c := range []Codec{JSONCodec, GobCodec, BinaryCodec} {
+		data, err := c.Marshal(in)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		var out codecValue
+		if err := c.Unmarshal(data, &out); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("%s: expected %v, got %v", c.Name(), in, out)
+		}
+	}
+
+	jsonData, _ := JSONCodec.Marshal(in)
+	binData, _ := BinaryCodec.Marshal(in)
+	if len(binData) >= len(jsonData) {
+		t.Errorf("expected the binary form to be smaller, got %d >= %d bytes", len(binData), len(jsonData))
+	}
+
+	var out interface{}
+	for _, data := range [][]byte{{}, {binString, 10, 'a'}, {0xff}, {binArray, 200}} {
+		if err := BinaryCodec.Unmarshal(data, &out); err == nil {
+			t.Errorf("expected %v to fail decoding", data)
+		}
+	}
+}
+
+type renamedCodec struct{ jsonCodec }
+
+func (renamedCodec) Name() string {
+	return "renamed"
+}
+
+func TestRegisterCodec(t *testing.T) {
+	if c, err := LookupCodec(""); err != nil || c != JSONCodec {
+		t.Errorf("expected an empty name to be JSON, got %v %v", c, err)
+	}
+	if _, err := LookupCodec("renamed"); err == nil {
+		t.Error("expected an unregistered codec to be missing")
+	}
+	if err := RegisterCodec(renamedCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterCodec(renamedCodec{}); err != nil {
+		t.Errorf("registering a codec again should be allowed, got %v", err)
+	}
+	if err := RegisterCodec(gobCodec{}); err != nil {
+		t.Errorf("registering a built in codec again should be allowed, got %v", err)
+	}
+	if err := RegisterCodec(struct{ renamedCodec }{}); err == nil {
+		t.Error("expected a different codec under a taken name to fail")
+	}
+	if c, err := LookupCodec("renamed"); err != nil || c != (renamedCodec{}) {
+		t.Errorf("expected the registered codec, got %v %v", c, err)
+	}
+
+	// typed codecs are told apart by their prototype
+	if err := RegisterCodec(NewGobCodec("typed", codecValue{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterCodec(NewGobCodec("typed", codecValue{})); err != nil {
+		t.Errorf("registering a typed codec again should be allowed, got %v", err)
+	}
+	if err := RegisterCodec(NewGobCodec("typed", renamedCodec{})); !errors.Is(err, ErrConstraint) {
+		t.Errorf("expected another prototype under a taken name to fail, got %v", err)
+	}
+	if c, err := LookupCodec("typed"); err != nil || reflect.TypeOf(c.(TypedCodec).New()) != reflect.TypeOf(&codecValue{}) {
+		t.Errorf("expected the first typed codec to stay registered, got %v %v", c, err)
+	}
+}
+
+func TestRecordEnvelope(t *testing.T) {
+	in := codecValue{Name: "a", Count: 1, Tags: []string{"x"}}
+	keys := map[string][][]byte{"byName": {[]byte("k1"), []byte("k2")}, "byTag": {[]byte("k3")}}
+	for _, c := range []Codec{JSONCodec, GobCodec, BinaryCodec} {
+		record := Record{IndexKeys: keys}
+		if err := record.encode(c, in); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		data := record.marshal()
+		if c != JSONCodec && len(data) > len(record.Data)+32 {
+			t.Errorf("%s: expected a compact envelope, %d bytes hold %d", c.Name(), len(data), len(record.Data))
+		}
+		stored, err := unmarshalRecord(data)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if !reflect.DeepEqual(stored.IndexKeys, keys) {
+			t.Errorf("%s: expected index keys %v, got %v", c.Name(), keys, stored.IndexKeys)
+		}
+		var out codecValue
+		if err := decodeRecord(c, data, &out); err != nil || !reflect.DeepEqual(in, out) {
+			t.Errorf("%s: expected %v, got %v %v", c.Name(), in, out, err)
+		}
+	}
+}
+
+type binaryEmbedded struct {
+	Inner  string `json:"inner"`
+	Shadow string
+}
+
+type binaryValue struct {
+	binaryEmbedded
+	Shadow  int               `json:"Shadow"`
+	When    time.Time         `json:"when"`
+	Skipped string            `json:"-"`
+	Empty   string            `json:"empty,omitempty"`
+	Bytes   []byte            `json:"bytes"`
+	Small   float32           `json:"small"`
+	Big     uint64            `json:"big"`
+	ByNum   map[int]string    `json:"byNum"`
+	Nested  []map[string]bool `json:"nested"`
+	Any     interface{}       `json:"any"`
+	private int
+}
+
+func TestBinaryCodecMatchesJSON(t *testing.T) {
+	in := binaryValue{
+		binaryEmbedded: binaryEmbedded{Inner: "i", Shadow: "hidden"},
+		Shadow:         4,
+		When:           time.Date(2020, 1, 2, 3, 4, 5, 6, time.FixedZone("x", 3600)),
+		Skipped:        "s",
+		Bytes:          []byte{1, 2, 3},
+		Small:          0.1,
+		Big:            1 << 63,
+		ByNum:          map[int]string{2: "b", 10: "a"},
+		Nested:         []map[string]bool{{"t": true}, nil},
+		Any:            []interface{}{1.0, 1.5, "x", nil},
+	}
+
+	// the binary form holds exactly the value's JSON form
+	jsonData, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := BinaryCodec.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transcoded bytes.Buffer
+	if err := decodeBinary(&transcoded, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(transcoded.Bytes(), jsonData) {
+		t.Errorf("expected %s, got %s", jsonData, transcoded.Bytes())
+	}
+
+	var out, want binaryValue
+	if err := BinaryCodec.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(jsonData, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !out.When.Equal(in.When) {
+		t.Errorf("expected %v, got %v", in.When, out.When)
+	}
+	out.When, want.When = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("expected %+v, got %+v", want, out)
+	}
+
+	var wrong struct {
+		Shadow string
+	}
+	if err := BinaryCodec.Unmarshal(data, &wrong); !errors.Is(err, ErrData) {
+		t.Errorf("expected a number decoded into a string to fail, got %v", err)
+	}
+}
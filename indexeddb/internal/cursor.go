@@ -1,10 +1,12 @@
 package internal
 
 import (
-	"encoding/json"
-	"fmt"
+	"bytes"
+	"reflect"
 
+	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 type KeyCursor interface {
@@ -16,6 +18,7 @@ type KeyCursor interface {
 	Advance(count int) bool
 	Continue() bool
 	ContinueTo(key Key) error
+	Token() (string, error)
 }
 
 type Cursor interface {
@@ -30,11 +33,17 @@ type MultiKeyCursor interface {
 	ContinuePrimaryKey(key Key, primaryKey Key) error
 }
 
+type MultiCursor interface {
+	MultiKeyCursor
+	Value(v interface{}) error
+}
+
 var _ KeyCursor = (*StoreCursor)(nil)
 var _ Cursor = (*StoreCursor)(nil)
 
 var _ KeyCursor = (*IndexCursor)(nil)
 var _ MultiKeyCursor = (*IndexCursor)(nil)
+var _ MultiCursor = (*IndexCursor)(nil)
 
 type Direction string
 
@@ -49,6 +58,12 @@ type BaseCursor struct {
 	iter       iterator.Iterator
 	direction  Direction
 	primaryKey Key // ??
+	started    bool
+	// bounds is the encoded range the cursor was opened on
+	bounds util.Range
+	// resume is the entry of the token the cursor was opened from, the first
+	// step moves past it
+	resume []byte
 }
 
 func (p *BaseCursor) Source() {
@@ -74,23 +89,118 @@ func (p *BaseCursor) Advance(count int) bool {
 	return true
 }
 
+// Continue moves the cursor to the next record in its direction. The first call
+// positions the cursor at the start of the range, or at its end for reverse cursors.
+// Store keys are unique, so the unique directions behave like their plain counterparts.
 func (p *BaseCursor) Continue() bool {
-	switch p.direction {
-	case PREV:
+	return p.step()
+}
+
+func (p *BaseCursor) reverse() bool {
+	return p.direction == PREV || p.direction == PREVUNIQUE
+}
+
+// step moves the iterator by a single entry
+func (p *BaseCursor) step() bool {
+	if !p.started {
+		p.started = true
+		if p.resume != nil {
+			return p.seekPast(p.resume)
+		}
+		if p.reverse() {
+			return p.iter.Last()
+		}
+		return p.iter.First()
+	}
+	if p.reverse() {
 		return p.iter.Prev()
-	case PREVUNIQUE:
-		// FIXME
+	}
+	return p.iter.Next()
+}
+
+// seek positions the cursor on the first entry at or after start, or for
+// reverse cursors on the last entry before limit
+func (p *BaseCursor) seek(bounds util.Range) bool {
+	p.started = true
+	if !p.reverse() {
+		return p.iter.Seek(bounds.Start)
+	}
+	if !p.iter.Seek(bounds.Limit) {
+		return p.iter.Last()
+	}
+	return p.iter.Prev()
+}
+
+// seekPast positions the cursor on the first entry beyond entry in its direction
+func (p *BaseCursor) seekPast(entry []byte) bool {
+	ok := p.iter.Seek(entry)
+	if p.reverse() {
+		if !ok {
+			return p.iter.Last()
+		}
 		return p.iter.Prev()
-	case NEXTUNIQUE:
-		// FIXME
+	}
+	if ok && bytes.Equal(p.iter.Key(), entry) {
 		return p.iter.Next()
 	}
-	return p.iter.Next()
+	return ok
+}
+
+// position compares the cursor's entry with the entries within bounds: -1 when
+// it comes before them, 1 after them and 0 within
+func (p *BaseCursor) position(bounds util.Range) int {
+	pos := p.iter.Key()
+	if bytes.Compare(pos, bounds.Start) < 0 {
+		return -1
+	}
+	if bytes.Compare(pos, bounds.Limit) >= 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkTarget rejects a target that is not beyond the cursor's entry in its
+// direction. cmp is the result of position, or of a finer comparison for it.
+func (p *BaseCursor) checkTarget(cmp int) error {
+	if (!p.reverse() && cmp >= 0) || (p.reverse() && cmp <= 0) {
+		return NewError(DataError, "target is not beyond the cursor's position in %s direction", p.direction)
+	}
+	return nil
+}
+
+// positioned reports whether the cursor is on an entry
+func (p *BaseCursor) positioned() bool {
+	return p.started && p.iter.Valid()
+}
+
+// resuming reports whether the next step is the first one of a cursor opened from a token
+func (p *BaseCursor) resuming() bool {
+	return !p.started && p.resume != nil
+}
+
+// token exports the cursor's position for a cursor opened later on the same source
+func (p *BaseCursor) token(db *Database, store string, index string) (string, error) {
+	if !p.started || !p.iter.Valid() {
+		return "", NewError(InvalidStateError, "cursor is not positioned on a record")
+	}
+	return db.signToken(cursorToken{
+		Store:     store,
+		Index:     index,
+		Direction: p.direction,
+		Start:     p.bounds.Start,
+		Limit:     p.bounds.Limit,
+		Position:  p.iter.Key(),
+	})
 }
 
 type StoreCursor struct {
 	store *Store
+	// tr is nil for cursors opened on a snapshot
+	tr *Transaction
 	BaseCursor
+	// updated is the record written by Update at the entry updatedAt, which the
+	// iterator still holds the old value of
+	updated, updatedAt []byte
 }
 
 func (p *StoreCursor) Key() (Key, error) {
@@ -99,57 +209,264 @@ func (p *StoreCursor) Key() (Key, error) {
 	return key, err
 }
 
+func (p *StoreCursor) PrimaryKey() Key {
+	key, _ := p.Key()
+	return key
+}
+
+// ContinueTo moves the cursor to key, or to the next record after it in the cursor's direction.
+// The key must be beyond the cursor's position in its direction.
 func (p *StoreCursor) ContinueTo(key Key) error {
-	k, err := key.forStore(p.store)
+	bounds, err := Only(key).forStore(p.store)
 	if err != nil {
 		return err
 	}
-	if !p.iter.Seek(k) {
-		return fmt.Errorf("key not found")
+	if p.positioned() {
+		if err := p.checkTarget(p.position(bounds)); err != nil {
+			return err
+		}
+	}
+	if !p.seek(bounds) {
+		return NewError(NotFoundError, "key not found")
 	}
 	return nil
 }
 
+// Token returns an opaque token for the cursor's position. Store.ResumeCursor opens a
+// cursor from it, in a later transaction, that continues with the next record.
+func (p *StoreCursor) Token() (string, error) {
+	return p.token(p.store.Database, p.store.Name, "")
+}
+
 func (p *StoreCursor) Value(val interface{}) error {
-	return json.Unmarshal(p.iter.Value(), val)
+	if p.updatedAt != nil && bytes.Equal(p.iter.Key(), p.updatedAt) {
+		return decodeRecord(p.store.codec, p.updated, val)
+	}
+	return decodeRecord(p.store.codec, p.iter.Value(), val)
 }
 
+// Delete removes the record at the cursor's position along with its index entries
 func (p *StoreCursor) Delete() error {
-	// TODO
-	return nil
+	if p.tr == nil || p.tr.ReadOnly() {
+		return NewError(ReadOnlyError, "cursor belongs to a readonly transaction")
+	}
+	key, err := p.Key()
+	if err != nil {
+		return err
+	}
+	return p.store.DeleteExact(p.tr, key)
 }
 
+// Update replaces the record at the cursor's position, keeping its index entries current.
+// For stores with a key path the value must carry the cursor's key.
 func (p *StoreCursor) Update(val interface{}) error {
-	// TODO
+	if p.tr == nil || p.tr.ReadOnly() {
+		return NewError(ReadOnlyError, "cursor belongs to a readonly transaction")
+	}
+	key, err := p.Key()
+	if err != nil {
+		return err
+	}
+	if len(p.store.KeyPath) > 0 {
+		k, ok, err := p.store.KeyPath.keyFor(val)
+		if !ok {
+			return NewError(DataError, "key path %s not found on value", p.store.KeyPath)
+		}
+		if err != nil || !reflect.DeepEqual(k, key) {
+			return NewError(DataError, "value key does not match the cursor key %v", key)
+		}
+	}
+	err = p.store.Put(p.tr, key, val)
+	if err != nil {
+		return err
+	}
+	entry := append([]byte{}, p.iter.Key()...)
+	data, err := p.tr.Get(entry, nil)
+	if err != nil {
+		return err
+	}
+	p.updated, p.updatedAt = data, entry
 	return nil
 }
 
 type IndexCursor struct {
 	idx *Index
+	r   leveldb.Reader
 	BaseCursor
 }
 
+// Key returns the index key at the cursor's position
 func (p *IndexCursor) Key() (Key, error) {
-	val := p.iter.Value()
-	// TODO should this return the index key or the doc key?
-	_, key, err := fromStore(val)
+	return p.indexKey()
+}
+
+func (p *IndexCursor) PrimaryKey() Key {
+	_, key, _ := fromStore(p.iter.Value())
+	return key
+}
+
+// Token returns an opaque token for the cursor's position. Index.ResumeCursor opens a
+// cursor from it, in a later transaction, that continues with the next entry.
+func (p *IndexCursor) Token() (string, error) {
+	return p.token(p.idx.Database, p.idx.StoreName, p.idx.Name)
+}
+
+// indexKey decodes the index key at the cursor's position
+func (p *IndexCursor) indexKey() (Key, error) {
+	_, key, err := fromIndex(p.idx, p.iter.Key())
 	return key, err
+}
+
+// Continue moves the cursor to the next entry in its direction. Unique directions
+// skip entries sharing an index key, stopping on the one with the lowest primary key.
+func (p *IndexCursor) Continue() bool {
+	switch p.direction {
+	case NEXTUNIQUE:
+		var current Key
+		if p.started && p.iter.Valid() {
+			current, _ = p.indexKey()
+		} else if p.resuming() {
+			_, current, _ = fromIndex(p.idx, p.resume)
+		}
+		for p.step() {
+			key, _ := p.indexKey()
+			if current == nil || !reflect.DeepEqual(key, current) {
+				return true
+			}
+		}
+		return false
+	case PREVUNIQUE:
+		var current Key
+		if p.resuming() {
+			_, current, _ = fromIndex(p.idx, p.resume)
+		}
+		if !p.step() {
+			return false
+		}
+		// entries added to the resumed key since the token was made are skipped
+		for current != nil {
+			key, _ := p.indexKey()
+			if !reflect.DeepEqual(key, current) {
+				break
+			}
+			if !p.iter.Prev() {
+				return false
+			}
+		}
+		return p.rewind()
+	}
+	return p.step()
+}
 
+// rewind moves back to the first entry sharing the current index key
+func (p *IndexCursor) rewind() bool {
+	current, _ := p.indexKey()
+	for p.iter.Prev() {
+		key, _ := p.indexKey()
+		if !reflect.DeepEqual(key, current) {
+			return p.iter.Next()
+		}
+	}
+	// ran past the start of the range, so the first entry shares the key
+	return p.iter.First()
+}
+
+func (p *IndexCursor) Advance(count int) bool {
+	for i := 0; i < count; i++ {
+		if !p.Continue() {
+			return false
+		}
+	}
+	return true
 }
 
+// ContinueTo moves the cursor to the first entry for key, or to the next entry
+// after it in the cursor's direction. The key must be beyond the cursor's
+// position in its direction.
 func (p *IndexCursor) ContinueTo(key Key) error {
-	k, err := key.forIndex(p.idx, key)
+	bounds, err := Only(key).forIndex(p.idx)
 	if err != nil {
 		return err
 	}
-	if !p.iter.Seek(k) {
-		return fmt.Errorf("key not found")
+	if p.positioned() {
+		if err := p.checkTarget(p.position(bounds)); err != nil {
+			return err
+		}
+	}
+	ok := p.seek(bounds)
+	if ok && p.direction == PREVUNIQUE {
+		ok = p.rewind()
+	}
+	if !ok {
+		return NewError(NotFoundError, "key not found")
 	}
 	return nil
 }
 
-// IndexCursor only
+// Value decodes the record the current entry refers to
+func (p *IndexCursor) Value(val interface{}) error {
+	store, ok := p.idx.Stores[p.idx.StoreName]
+	if !ok {
+		return NewError(NotFoundError, "store %s not found", p.idx.StoreName)
+	}
+	data, err := p.r.Get(p.iter.Value(), nil)
+	if err != nil {
+		return err
+	}
+	return decodeRecord(store.codec, data, val)
+}
+
+// ContinuePrimaryKey moves the cursor to the entry for key and primaryKey, or to
+// the next entry after it in the cursor's direction. The entry must be beyond
+// the cursor's position in its direction.
 func (p *IndexCursor) ContinuePrimaryKey(key Key, primaryKey Key) error {
-	// TODO needs store access
+	if p.direction == NEXTUNIQUE || p.direction == PREVUNIQUE {
+		return NewError(InvalidStateError, "cannot continue to a primary key in %s direction", p.direction)
+	}
+	store, ok := p.idx.Stores[p.idx.StoreName]
+	if !ok {
+		return NewError(NotFoundError, "store %s not found", p.idx.StoreName)
+	}
+	target, err := primaryKey.forStore(store)
+	if err != nil {
+		return err
+	}
+
+	bounds, err := Only(key).forIndex(p.idx)
+	if err != nil {
+		return err
+	}
+	if p.positioned() {
+		cmp := p.position(bounds)
+		if cmp == 0 {
+			// entries of the same index key are ordered by primary key
+			cmp = bytes.Compare(p.iter.Value(), target)
+		}
+		if err := p.checkTarget(cmp); err != nil {
+			return err
+		}
+	}
+	if !p.idx.Unique {
+		// entries are ordered by primary key within an index key
+		entry, err := key.forIndex(p.idx, primaryKey)
+		if err != nil {
+			return err
+		}
+		bounds.Start = entry
+		bounds.Limit = append(entry[:len(entry):len(entry)], afterKey)
+	}
+
+	ok = p.seek(bounds)
+	if ok && p.idx.Unique {
+		// a single entry per key, step past it when its primary key is on the wrong side
+		current, _ := p.indexKey()
+		cmp := bytes.Compare(p.iter.Value(), target)
+		if reflect.DeepEqual(current, key) && ((!p.reverse() && cmp < 0) || (p.reverse() && cmp > 0)) {
+			ok = p.step()
+		}
+	}
+	if !ok {
+		return NewError(NotFoundError, "key not found")
+	}
 	return nil
 }
@@ -2,11 +2,13 @@ package internal
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"path/filepath"
 
+	"github.com/huffduff/go-indexeddb/bytewise"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
@@ -15,6 +17,8 @@ type Database struct {
 	Name    string            `json:"name"`
 	Version uint              `json:"version"`
 	Stores  map[string]*Store `json:"-"`
+	// Secret signs cursor tokens
+	Secret []byte `json:"secret,omitempty"`
 }
 
 func (p *Database) StoreNames() []string {
@@ -25,12 +29,29 @@ func (p *Database) StoreNames() []string {
 	return keys
 }
 
-func (p *Database) UpdateDefinition(r *leveldb.Transaction) error {
+// UpdateDefinition stores the definition within a versionchange transaction.
+// Databases without a token secret get one, so it is stored once with the first
+// migration and never written by merely opening the database.
+func (p *Database) UpdateDefinition(r *Transaction) error {
+	if len(p.Secret) == 0 {
+		secret, err := newSecret()
+		if err != nil {
+			return err
+		}
+		p.Secret = secret
+	}
 	def, _ := json.Marshal(p)
 	return r.Put(Key{}.forCore(), def, nil)
 }
 
-func (p *Database) CreateStore(r *leveldb.Transaction, spec Store) (*Store, error) {
+func (p *Database) CreateStore(r *Transaction, spec Store) (*Store, error) {
+	if _, err := LookupCodec(spec.Codec); err != nil {
+		return nil, err
+	}
+	if spec.AutoIncrement && spec.KeyPath.Compound() {
+		return nil, NewError(InvalidAccessError, "store %s cannot combine a key generator with the compound key path %s", spec.Name, spec.KeyPath)
+	}
+
 	val, _ := json.Marshal(spec)
 
 	key := Key{"store", spec.Name}.forCore()
@@ -46,31 +67,357 @@ func (p *Database) CreateStore(r *leveldb.Transaction, spec Store) (*Store, erro
 	return store, nil
 }
 
-func (p *Database) CreateIndex(r *leveldb.Transaction, spec Index) (*Index, error) {
-	val, _ := json.Marshal(spec)
+// CreateIndex stores the index spec and writes entries for the records already in the store
+func (p *Database) CreateIndex(r *Transaction, spec Index) (*Index, error) {
+	store, ok := p.Stores[spec.StoreName]
+	if !ok {
+		return nil, NewError(NotFoundError, "store %s not found", spec.StoreName)
+	}
+	if _, ok := store.Indexes[spec.Name]; ok {
+		return nil, NewError(ConstraintError, "index %s already exists on store %s", spec.Name, store.Name)
+	}
+	if spec.MultiEntry && spec.KeyPath.Compound() {
+		return nil, NewError(InvalidAccessError, "multiEntry index %s cannot use the compound key path %s", spec.Name, spec.KeyPath)
+	}
 
-	key := Key{"index", spec.Name}.forCore()
+	index := NewIndex(p, spec)
 
-	err := r.Put(key, val, nil)
+	err := r.Put(index.specKey(), index.marshalSpec(), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	index := NewIndex(p, spec)
+	err = index.backfill(r, store)
+	if err != nil {
+		return nil, err
+	}
 
+	store.Indexes[index.Name] = index
 	return index, nil
 }
 
-func (p *Database) DeleteIndex(r *leveldb.Transaction, idx *Index) error {
-	err := idx.Clear(r)
+// DeleteIndex removes the index's entries and spec. Records drop their copy of
+// the index's entry keys, so an index created later under the same name starts clean.
+func (p *Database) DeleteIndex(r *Transaction, idx *Index) error {
+	return p.deleteIndex(r, idx, true)
+}
+
+// deleteIndex is DeleteIndex, leaving the records alone unless strip is set
+func (p *Database) deleteIndex(r *Transaction, idx *Index, strip bool) error {
+	q, err := Range{}.forIndex(idx)
+	if err != nil {
+		return err
+	}
+	b := &leveldb.Batch{}
+	// entries point at their record, multiEntry records have several
+	records := make(map[string]struct{})
+	iter := r.NewIterator(&q, nil)
+	for iter.Next() {
+		b.Delete(iter.Key())
+		if strip {
+			records[string(iter.Value())] = struct{}{}
+		}
+	}
+	err = iter.Error()
+	iter.Release()
+	if err != nil {
+		return err
+	}
+	for key := range records {
+		data, err := r.Get([]byte(key), nil)
+		if errors.Is(err, leveldb.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		record, err := unmarshalRecord(data)
+		if err != nil {
+			return err
+		}
+		delete(record.IndexKeys, idx.Name)
+		b.Put([]byte(key), record.marshal())
+	}
+	err = r.Write(b, nil)
+	if err != nil {
+		return err
+	}
+	if store, ok := p.Stores[idx.StoreName]; ok {
+		delete(store.Indexes, idx.Name)
+	}
+	return r.Delete(idx.specKey(), nil)
+}
+
+// DeleteStore removes the store's records, its indexes and its spec
+func (p *Database) DeleteStore(r *Transaction, store *Store) error {
+	// the records go as well, so they keep their index keys until then
+	for _, idx := range store.Indexes {
+		err := p.deleteIndex(r, idx, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	q, err := Range{}.forStore(store)
+	if err != nil {
+		return err
+	}
+	err = p.deleteRange(r, q)
+	if err != nil {
+		return err
+	}
+
+	b := &leveldb.Batch{}
+	b.Delete(store.generatorKey())
+	b.Delete(Key{"store", store.Name}.forCore())
+	err = r.Write(b, nil)
+	if err != nil {
+		return err
+	}
+
+	delete(p.Stores, store.Name)
+	return nil
+}
+
+// RenameStore moves the store's records and spec to a new name
+func (p *Database) RenameStore(r *Transaction, store *Store, name string) error {
+	if _, ok := p.Stores[name]; ok {
+		return NewError(ConstraintError, "store %s already exists", name)
+	}
+
+	renamed := NewStore(p, Store{Name: name, KeyPath: store.KeyPath, AutoIncrement: store.AutoIncrement, Codec: store.Codec})
+
+	b := &leveldb.Batch{}
+
+	// records
+	q, err := Range{}.forStore(store)
+	if err != nil {
+		return err
+	}
+	iter := r.NewIterator(&q, nil)
+	for iter.Next() {
+		_, key, err := fromStore(iter.Key())
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		primaryKey, err := key.forStore(renamed)
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		// records keep a copy of their index entry keys, which include the store name
+		record, err := unmarshalRecord(iter.Value())
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		for _, keys := range record.IndexKeys {
+			for i, k := range keys {
+				keys[i], err = moveIndexKey(k, name, "")
+				if err != nil {
+					iter.Release()
+					return err
+				}
+			}
+		}
+		b.Delete(iter.Key())
+		b.Put(primaryKey, record.marshal())
+	}
+	err = iter.Error()
+	iter.Release()
+	if err != nil {
+		return err
+	}
+
+	// index entries are scoped by the store and point at the primary key,
+	// both include the store name
+	for _, idx := range store.Indexes {
+		q, err := Range{}.forIndex(idx)
+		if err != nil {
+			return err
+		}
+		iter := r.NewIterator(&q, nil)
+		for iter.Next() {
+			_, key, err := fromStore(iter.Value())
+			if err != nil {
+				iter.Release()
+				return err
+			}
+			primaryKey, err := key.forStore(renamed)
+			if err != nil {
+				iter.Release()
+				return err
+			}
+			moved, err := moveIndexKey(iter.Key(), name, "")
+			if err != nil {
+				iter.Release()
+				return err
+			}
+			b.Delete(iter.Key())
+			b.Put(moved, primaryKey)
+		}
+		err = iter.Error()
+		iter.Release()
+		if err != nil {
+			return err
+		}
+
+		moved := NewIndex(p, *idx)
+		moved.StoreName = name
+		b.Delete(idx.specKey())
+		b.Put(moved.specKey(), moved.marshalSpec())
+	}
+
+	// key generator
+	gen, err := r.Get(store.generatorKey(), nil)
+	if err == nil {
+		b.Delete(store.generatorKey())
+		b.Put(renamed.generatorKey(), gen)
+	} else if err != leveldb.ErrNotFound {
+		return err
+	}
+
+	b.Delete(Key{"store", store.Name}.forCore())
+	b.Put(Key{"store", name}.forCore(), renamed.marshalSpec())
+
+	err = r.Write(b, nil)
+	if err != nil {
+		return err
+	}
+
+	delete(p.Stores, store.Name)
+	store.Name = name
+	for _, idx := range store.Indexes {
+		idx.StoreName = name
+	}
+	p.Stores[name] = store
+	return nil
+}
+
+// RenameIndex moves the index's entries and spec to a new name
+func (p *Database) RenameIndex(r *Transaction, idx *Index, name string) error {
+	store, ok := p.Stores[idx.StoreName]
+	if !ok {
+		return NewError(NotFoundError, "store %s not found", idx.StoreName)
+	}
+	if _, ok := store.Indexes[name]; ok {
+		return NewError(ConstraintError, "index %s already exists on store %s", name, store.Name)
+	}
+
+	renamed := NewIndex(p, *idx)
+	renamed.Name = name
+
+	b := &leveldb.Batch{}
+
+	// entries
+	q, err := Range{}.forIndex(idx)
+	if err != nil {
+		return err
+	}
+	iter := r.NewIterator(&q, nil)
+	for iter.Next() {
+		moved, err := moveIndexKey(iter.Key(), "", name)
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		b.Delete(iter.Key())
+		b.Put(moved, iter.Value())
+	}
+	err = iter.Error()
+	iter.Release()
+	if err != nil {
+		return err
+	}
+
+	// records keep a copy of their index keys
+	q, err = Range{}.forStore(store)
 	if err != nil {
 		return err
 	}
-	return r.Delete(Key{"idx", idx.Name}.forCore(), nil)
+	iter = r.NewIterator(&q, nil)
+	for iter.Next() {
+		record, err := unmarshalRecord(iter.Value())
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		keys, ok := record.IndexKeys[idx.Name]
+		if !ok {
+			continue
+		}
+		for i, k := range keys {
+			keys[i], err = moveIndexKey(k, "", name)
+			if err != nil {
+				iter.Release()
+				return err
+			}
+		}
+		delete(record.IndexKeys, idx.Name)
+		record.IndexKeys[name] = keys
+		b.Put(iter.Key(), record.marshal())
+	}
+	err = iter.Error()
+	iter.Release()
+	if err != nil {
+		return err
+	}
+
+	b.Delete(idx.specKey())
+	b.Put(renamed.specKey(), renamed.marshalSpec())
+
+	err = r.Write(b, nil)
+	if err != nil {
+		return err
+	}
+
+	delete(store.Indexes, idx.Name)
+	idx.Name = name
+	store.Indexes[name] = idx
+	return nil
+}
+
+// moveIndexKey rewrites an encoded index entry key for a different store or
+// index name, leaving the parts given as "" alone
+func moveIndexKey(src []byte, store string, index string) ([]byte, error) {
+	raw, err := rawKey(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < 3 || raw[0] != "idx" {
+		return nil, NewError(DataError, "key is not a valid index key")
+	}
+	if store != "" {
+		raw[1] = store
+	}
+	if index != "" {
+		raw[2] = index
+	}
+	return bytewise.Encode(raw)
+}
+
+// deleteRange removes every key within q
+func (p *Database) deleteRange(r *Transaction, q util.Range) error {
+	b := &leveldb.Batch{}
+	iter := r.NewIterator(&q, nil)
+	for iter.Next() {
+		b.Delete(iter.Key())
+	}
+	err := iter.Error()
+	iter.Release()
+	if err != nil {
+		return err
+	}
+	return r.Write(b, nil)
 }
 
 func (p *Database) GetExact(r leveldb.Reader, k []byte) ([]byte, error) {
-	return r.Get(k, nil)
+	val, err := r.Get(k, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, &Error{NotFoundError, "record not found", err}
+	}
+	return val, err
 }
 
 func (p *Database) Get(r leveldb.Reader, k util.Range) ([]byte, []byte, error) {
@@ -78,19 +425,22 @@ func (p *Database) Get(r leveldb.Reader, k util.Range) ([]byte, []byte, error) {
 	defer iter.Release()
 
 	if !iter.First() {
-		return nil, nil, fmt.Errorf("record not found")
+		return nil, nil, NewError(NotFoundError, "record not found")
 	}
 	return iter.Key(), iter.Value(), nil
 }
 
-func (p *Database) GetIter(r leveldb.Reader, k util.Range, cb func(key []byte, val []byte) bool) {
+// GetIter calls cb for every entry within k until it returns false.
+// It returns the error that stopped the iteration, if any.
+func (p *Database) GetIter(r leveldb.Reader, k util.Range, cb func(key []byte, val []byte) bool) error {
 	iter := r.NewIterator(&k, nil)
+	defer iter.Release()
 	for iter.Next() {
 		if !cb(iter.Key(), iter.Value()) {
 			break
 		}
 	}
-	iter.Release()
+	return iter.Error()
 }
 
 func (p *Database) GetMulti(r leveldb.Reader, keys [][]byte, cb func(row []byte) error) error {
@@ -116,30 +466,44 @@ func (p *Database) Count(r leveldb.Reader, k util.Range) (uint, error) {
 		count += 1
 	}
 
-	return count, nil
+	return count, iter.Error()
 }
 
 // hydrate loads the known store and index definitions into the database instance
 func (p *Database) Hydrate() error {
 	p.Stores = make(map[string]*Store)
-	iter := p.NewIterator(Range{Start: &Key{"store"}, Prefix: true}.forCore(), nil)
+	q, err := Range{Start: &Key{"store"}, Prefix: true}.forCore()
+	if err != nil {
+		return err
+	}
+	iter := p.NewIterator(&q, nil)
 	for iter.Next() {
 		var spec Store
 		err := json.Unmarshal(iter.Value(), &spec)
 		if err != nil {
+			iter.Release()
 			return err
 		}
+		if _, err := LookupCodec(spec.Codec); err != nil {
+			iter.Release()
+			return NewError(NotFoundError, "store %s uses codec %s, which is not registered", spec.Name, spec.Codec)
+		}
 		store := NewStore(p, spec)
 
 		p.Stores[store.Name] = store
 	}
 	iter.Release()
 
-	iter = p.NewIterator(Range{Start: &Key{"index"}, Prefix: true}.forCore(), nil)
+	q, err = Range{Start: &Key{"index"}, Prefix: true}.forCore()
+	if err != nil {
+		return err
+	}
+	iter = p.NewIterator(&q, nil)
 	for iter.Next() {
 		var spec Index
 		err := json.Unmarshal(iter.Value(), &spec)
 		if err != nil {
+			iter.Release()
 			return err
 		}
 
@@ -160,18 +524,61 @@ func OpenDatabase(name string, path string) (*Database, error) {
 		return nil, err
 	}
 
-	def := Database{h, name, 0, make(map[string]*Store)}
+	def, err := loadDefinition(h, name)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	return def, nil
+}
+
+// OpenStorage opens a database kept in stor instead of a directory.
+// The storage is not closed with the database.
+func OpenStorage(name string, stor storage.Storage) (*Database, error) {
+	h, err := leveldb.Open(stor, &opt.Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	def, err := loadDefinition(h, name)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	return def, nil
+}
+
+// ReadDefinition loads the definition of a database that is not currently open.
+// The returned definition has no storage handle attached.
+func ReadDefinition(name string, path string) (*Database, error) {
+	h, err := leveldb.OpenFile(filepath.Join(path, name), &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+
+	def, err := loadDefinition(h, name)
+	if err != nil {
+		return nil, err
+	}
+	def.DB = nil
+	return def, nil
+}
+
+// loadDefinition reads the stored definition without writing to the database
+func loadDefinition(h *leveldb.DB, name string) (*Database, error) {
+	def := Database{DB: h, Name: name, Stores: make(map[string]*Store)}
 
 	data, err := h.Get(Key{}.forCore(), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return &def, nil
+	}
 	if err != nil {
-		if err != leveldb.ErrNotFound {
-			return nil, err
-		}
-	} else {
-		err = json.Unmarshal(data, &def)
-		if err != nil {
-			return nil, err
-		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, &def)
+	if err != nil {
+		return nil, err
 	}
 	return &def, nil
 }
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorName is the kind of an error, named after the DOMException names used by IndexedDB
+type ErrorName string
+
+const (
+	// NotFoundError: the requested record, store, index or database does not exist
+	NotFoundError ErrorName = "NotFoundError"
+	// ConstraintError: a write conflicts with existing data, such as a duplicate key
+	ConstraintError ErrorName = "ConstraintError"
+	// DataError: a key or value is not valid for the operation
+	DataError ErrorName = "DataError"
+	// ReadOnlyError: a write was attempted in a readonly transaction
+	ReadOnlyError ErrorName = "ReadOnlyError"
+	// TransactionInactiveError: the transaction has already been committed or aborted
+	TransactionInactiveError ErrorName = "TransactionInactiveError"
+	// VersionError: the database exists with a higher version than requested
+	VersionError ErrorName = "VersionError"
+	// AbortError: the transaction was aborted
+	AbortError ErrorName = "AbortError"
+	// InvalidStateError: the operation is not allowed in the current state
+	InvalidStateError ErrorName = "InvalidStateError"
+	// InvalidAccessError: a store or index was defined with options that cannot be combined
+	InvalidAccessError ErrorName = "InvalidAccessError"
+)
+
+// Error is an error of a known kind.
+// errors.Is reports a match for any two errors with the same name,
+// so an error can be compared against the sentinel of its kind.
+type Error struct {
+	Name    ErrorName
+	Message string
+	// Err is the underlying cause, if any
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return string(e.Name)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Name == e.Name
+}
+
+// NewError creates an error of the given kind. The message is formatted like
+// fmt.Errorf and a %w argument becomes the cause.
+func NewError(name ErrorName, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	return &Error{name, err.Error(), errors.Unwrap(err)}
+}
+
+var (
+	ErrNotFound            = &Error{Name: NotFoundError}
+	ErrConstraint          = &Error{Name: ConstraintError}
+	ErrData                = &Error{Name: DataError}
+	ErrReadOnly            = &Error{Name: ReadOnlyError, Message: "transaction is read only"}
+	ErrTransactionInactive = &Error{Name: TransactionInactiveError, Message: "transaction is not active"}
+	ErrVersion             = &Error{Name: VersionError}
+	ErrAbort               = &Error{Name: AbortError, Message: "transaction was aborted"}
+	ErrInvalidState        = &Error{Name: InvalidStateError}
+	ErrInvalidAccess       = &Error{Name: InvalidAccessError}
+)
+
+// ErrStop is returned by a ForEach callback to end the iteration early.
+// ForEach itself then returns nil.
+var ErrStop = errors.New("stop iteration")
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// maxGeneratedKey is the largest integer a float64 key can represent exactly
+const maxGeneratedKey = 1 << 53
+
+func (p *Store) generatorKey() []byte {
+	return Key{"generator", p.Name}.forCore()
+}
+
+// currentNumber reads the next key the store's generator will hand out,
+// including the advances made earlier in the batch
+func (p *Store) currentNumber(w *bulkWriter) (uint64, error) {
+	data, err := w.get(p.generatorKey())
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var current uint64
+	err = json.Unmarshal(data, &current)
+	return current, err
+}
+
+func (p *Store) setCurrentNumber(w *bulkWriter, current uint64) {
+	data, _ := json.Marshal(current)
+	w.set(p.generatorKey(), data)
+}
+
+// generateKey returns the next key of the store's generator. The generator only
+// moves past it once a record is put under it, see nextNumber.
+func (p *Store) generateKey(w *bulkWriter) (Key, error) {
+	current, err := p.currentNumber(w)
+	if err != nil {
+		return nil, err
+	}
+	if current > maxGeneratedKey {
+		return nil, NewError(ConstraintError, "key generator for store %s is exhausted", p.Name)
+	}
+	return Key{float64(current)}, nil
+}
+
+// nextNumber returns the generator state that moves past a numeric key, generated
+// or explicitly provided, or 0 if the generator is already past it
+func (p *Store) nextNumber(w *bulkWriter, key Key) (uint64, error) {
+	if !p.AutoIncrement || len(key) != 1 {
+		return 0, nil
+	}
+	n, ok := key[0].(float64)
+	if !ok || n < 1 {
+		return 0, nil
+	}
+	current, err := p.currentNumber(w)
+	if err != nil {
+		return 0, err
+	}
+	if n < float64(current) {
+		return 0, nil
+	}
+	if n >= maxGeneratedKey {
+		return maxGeneratedKey + 1, nil
+	}
+	return uint64(math.Floor(n)) + 1, nil
+}
+
+// injectKey writes a generated key into value at a dotted path
+func injectKey(value interface{}, path string, key float64) error {
+	v := reflect.ValueOf(value)
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return NewError(DataError, "key path %s not found on value", path)
+			}
+			v = v.Elem()
+		}
+		last := i == len(names)-1
+
+		switch v.Kind() {
+		case reflect.Map:
+			m, ok := v.Interface().(map[string]interface{})
+			if !ok {
+				return NewError(DataError, "%T cannot hold a generated key", value)
+			}
+			if last {
+				m[name] = key
+				return nil
+			}
+			next, ok := m[name]
+			if !ok {
+				return NewError(DataError, "key path %s not found on value", path)
+			}
+			v = reflect.ValueOf(next)
+		case reflect.Struct:
+			f, ok := fieldByJSONName(v, name)
+			if !ok {
+				return NewError(DataError, "key path %s not found on value", path)
+			}
+			if last {
+				return setGeneratedKey(f, value, path, key)
+			}
+			v = f
+		default:
+			return NewError(DataError, "key path %s not found on value", path)
+		}
+	}
+	return nil
+}
+
+// setGeneratedKey stores key in the struct field f
+func setGeneratedKey(f reflect.Value, value interface{}, path string, key float64) error {
+	if !f.CanSet() {
+		return NewError(DataError, "%T cannot hold a generated key, pass a pointer or a map", value)
+	}
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f.SetInt(int64(key))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f.SetUint(uint64(key))
+	case reflect.Float32, reflect.Float64:
+		f.SetFloat(key)
+	case reflect.Interface:
+		f.Set(reflect.ValueOf(key))
+	default:
+		return NewError(DataError, "key path %s cannot hold a generated key", path)
+	}
+	return nil
+}
@@ -1,9 +1,11 @@
 package internal
 
 import (
+	"encoding/json"
 	"reflect"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 type Indexer interface {
@@ -13,41 +15,142 @@ type Indexer interface {
 type Index struct {
 	*Database
 
-	Name       string `json:"name"`
-	StoreName  string `json:"store"`
-	KeyPath    string `json:"keypath,omitempty"`
-	Unique     bool   `json:"unique"`
-	MultiEntry bool   `json:"multiEntry"`
+	Name       string  `json:"name"`
+	StoreName  string  `json:"store"`
+	KeyPath    KeyPath `json:"keypath,omitempty"`
+	Unique     bool    `json:"unique"`
+	MultiEntry bool    `json:"multiEntry"`
 }
 
-// TODO This seems pricey
-func (p *Index) Keys(id interface{}, record interface{}) []Key {
+// Keys computes the index keys for record. Records implementing Indexer provide
+// their own keys, otherwise the index's key path is evaluated against the record.
+// Values that are missing or not valid keys are left out of the index.
+func (p *Index) Keys(record interface{}) []Key {
 	// if our record implements its own Keys method, use it
 	if m, ok := record.(Indexer); ok {
-		return p.enforceUnique(id, m.Keys(p.Name))
+		keys := make([]Key, 0)
+		for _, key := range m.Keys(p.Name) {
+			if k, err := toKey([]interface{}(key)); err == nil {
+				keys = append(keys, k.([]interface{}))
+			}
+		}
+		return keys
+	}
+
+	if len(p.KeyPath) == 0 {
+		return []Key{}
 	}
 
-	r := reflect.ValueOf(record)
-	val := r.FieldByName(p.KeyPath)
 	if p.MultiEntry {
-		s := val.Len()
-		keys := make([]Key, s)
-		for i := 0; i < s; i++ {
-			keys[i] = Key{val.Index(s).Interface()}
+		// multiEntry indexes always have a single key path
+		val, ok := evaluateKeyPath(record, p.KeyPath[0])
+		if !ok {
+			return []Key{}
+		}
+		v := reflect.ValueOf(val)
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			keys := make([]Key, 0, v.Len())
+		entry:
+			for i := 0; i < v.Len(); i++ {
+				// invalid entries are skipped rather than failing the whole record
+				e, err := toKey(v.Index(i).Interface())
+				if err != nil {
+					continue
+				}
+				for _, seen := range keys {
+					if reflect.DeepEqual(seen[0], e) {
+						continue entry
+					}
+				}
+				keys = append(keys, Key{e})
+			}
+			return keys
 		}
-		return p.enforceUnique(id, keys)
 	}
 
-	return p.enforceUnique(id, []Key{{val.Interface()}})
+	key, ok, err := p.KeyPath.keyFor(record)
+	if !ok || err != nil {
+		return []Key{}
+	}
+	return []Key{key}
 }
 
-func (p *Index) enforceUnique(id interface{}, keys []Key) []Key {
-	if !p.Unique {
-		for i := range keys {
-			keys[i] = append(keys[i], id)
+// entries encodes the index keys of record, keyed by the encoded index key
+func (p *Index) entries(primaryKey Key, record interface{}) ([][]byte, error) {
+	keys := p.Keys(record)
+	out := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		k, err := key.forIndex(p, primaryKey)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+// backfill writes entries for every record already in the store. Unique indexes
+// fail if two records share a key.
+func (p *Index) backfill(tr *Transaction, store *Store) error {
+	q, err := Range{}.forStore(store)
+	if err != nil {
+		return err
+	}
+
+	iter := tr.NewIterator(&q, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		_, key, err := fromStore(iter.Key())
+		if err != nil {
+			return err
+		}
+
+		record, err := unmarshalRecord(iter.Value())
+		if err != nil {
+			return err
+		}
+		value, err := record.decodeValue(store.codec)
+		if err != nil {
+			return NewError(DataError, "cannot decode record %v for index %s: %w", key, p.Name, err)
+		}
+
+		entries, err := p.entries(key, value)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		b := &leveldb.Batch{}
+		for _, k := range entries {
+			if p.Unique {
+				exists, err := tr.Has(k, nil)
+				if err != nil {
+					return err
+				}
+				if exists {
+					return NewError(ConstraintError, "unique index %s already has an entry for the key of record %v", p.Name, key)
+				}
+			}
+			b.Put(k, iter.Key())
+		}
+
+		if len(entries) > 0 {
+			if record.IndexKeys == nil {
+				record.IndexKeys = make(map[string][][]byte)
+			}
+			record.IndexKeys[p.Name] = entries
+		}
+		b.Put(iter.Key(), record.marshal())
+
+		err = tr.Write(b, nil)
+		if err != nil {
+			return err
 		}
 	}
-	return keys
+	return iter.Error()
 }
 
 func (p *Index) GetExact(r leveldb.Reader, key Key) (Key, error) {
@@ -87,21 +190,64 @@ func (p *Index) GetAll(r leveldb.Reader, query Range, limit int) ([]Key, error)
 		return nil, err
 	}
 
-	out := make([]Key, limit)
-
-	i := 0
+	out := make([]Key, 0)
 
-	p.Database.GetIter(r, q, func(key, val []byte) bool {
-		// TODO handle corrupt key error?
-		_, primaryKey, _ := fromStore(val)
+	iterErr := p.Database.GetIter(r, q, func(key, val []byte) bool {
+		var primaryKey Key
+		_, primaryKey, err = fromStore(val)
+		if err != nil {
+			return false
+		}
 		out = append(out, primaryKey)
-		i++
-		return i != limit
+		return len(out) != limit
 	})
+	if err != nil {
+		return nil, err
+	}
+	if iterErr != nil {
+		return nil, iterErr
+	}
 
 	return out, nil
 }
 
+// ForEach calls fn with the primary key and a decoder for every record referenced
+// within query, in index key order, reading one record at a time
+func (p *Index) ForEach(r leveldb.Reader, query Range, fn func(primaryKey Key, value Decoder) error) error {
+	store, ok := p.Stores[p.StoreName]
+	if !ok {
+		return NewError(NotFoundError, "store %s not found", p.StoreName)
+	}
+	q, err := query.forIndex(p)
+	if err != nil {
+		return err
+	}
+
+	iterErr := p.Database.GetIter(r, q, func(key, val []byte) bool {
+		var primaryKey Key
+		_, primaryKey, err = fromStore(val)
+		if err != nil {
+			return false
+		}
+		var data []byte
+		data, err = p.Database.GetExact(r, val)
+		if err != nil {
+			return false
+		}
+		err = fn(primaryKey, func(v interface{}) error {
+			return decodeRecord(store.codec, data, v)
+		})
+		return err == nil
+	})
+	if err == ErrStop {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return iterErr
+}
+
 func (p *Index) Count(r leveldb.Reader, query Range) (uint, error) {
 	q, err := query.forIndex(p)
 	if err != nil {
@@ -110,26 +256,78 @@ func (p *Index) Count(r leveldb.Reader, query Range) (uint, error) {
 	return p.Database.Count(r, q)
 }
 
+// GetAllRecords returns the index key, primary key and value of the records selected by opts
+func (p *Index) GetAllRecords(r leveldb.Reader, opts GetAllOptions) ([]Entry, error) {
+	store, ok := p.Stores[p.StoreName]
+	if !ok {
+		return nil, NewError(NotFoundError, "store %s not found", p.StoreName)
+	}
+	direction := opts.Direction
+	if direction == "" {
+		direction = NEXT
+	}
+	c, err := p.GetCursor(r, opts.Query, direction)
+	if err != nil {
+		return nil, err
+	}
+	return getAllEntries(c, c.iter, opts, func() (Entry, error) {
+		key, err := c.Key()
+		if err != nil {
+			return Entry{}, err
+		}
+		data, err := p.Database.GetExact(r, c.iter.Value())
+		if err != nil {
+			return Entry{}, err
+		}
+		return Entry{key, c.PrimaryKey(), data, store.codec}, nil
+	})
+}
+
 func (p *Index) GetCursor(r leveldb.Reader, query Range, dir Direction) (*IndexCursor, error) {
 	q, err := query.forIndex(p)
 	if err != nil {
 		return nil, err
 	}
 	iter := r.NewIterator(&q, nil)
-	return &IndexCursor{p, BaseCursor{iter: iter, direction: dir}}, nil
+	return &IndexCursor{p, r, BaseCursor{iter: iter, direction: dir, bounds: q}}, nil
 }
 
-func (p *Index) Clear(r *leveldb.Transaction) error {
-	q, _ := Range{Start: &Key{}, Prefix: true}.forIndex(p)
-	b := &leveldb.Batch{}
+// ResumeCursor opens a cursor from a token made by a cursor of this index. Its
+// first step moves to the entry after the one the token was made on.
+func (p *Index) ResumeCursor(r leveldb.Reader, token string) (*IndexCursor, error) {
+	t, err := p.Database.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if t.Store != p.StoreName || t.Index != p.Name {
+		return nil, NewError(DataError, "cursor token does not belong to index %s", p.Name)
+	}
+	q := util.Range{Start: t.Start, Limit: t.Limit}
 	iter := r.NewIterator(&q, nil)
-	for iter.Next() {
-		b.Delete(iter.Key())
+	return &IndexCursor{p, r, BaseCursor{iter: iter, direction: t.Direction, bounds: q, resume: t.Position}}, nil
+}
+
+// Clear removes every entry of the index within the transaction
+func (p *Index) Clear(tr *Transaction) error {
+	q, err := Range{}.forIndex(p)
+	if err != nil {
+		return err
 	}
-	iter.Release()
-	return p.Database.Write(b, nil)
+	return p.Database.deleteRange(tr, q)
 }
 
 func NewIndex(h *Database, spec Index) *Index {
 	return &Index{h, spec.Name, spec.StoreName, spec.KeyPath, spec.Unique, spec.MultiEntry}
 }
+
+// specKey is the core key of the index spec, scoped by the store like its entries
+func (p *Index) specKey() []byte {
+	return Key{"index", p.StoreName, p.Name}.forCore()
+}
+
+// marshalSpec encodes the index spec without the database it belongs to
+func (p Index) marshalSpec() []byte {
+	p.Database = nil
+	val, _ := json.Marshal(p)
+	return val
+}
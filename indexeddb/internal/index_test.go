@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type indexed struct {
+	Author string   `json:"author"`
+	Tags   []string `json:"tags"`
+}
+
+func (p indexed) Keys(idx string) []Key {
+	if idx == "custom" {
+		return []Key{{p.Author, 1}}
+	}
+	return []Key{}
+}
+
+func TestIndexKeys(t *testing.T) {
+	record := map[string]interface{}{
+		"author": "ann",
+		"tags":   []interface{}{"a", "b", "a", true},
+	}
+
+	for _, c := range []struct {
+		idx      Index
+		record   interface{}
+		expected []Key
+	}{
+		{Index{KeyPath: KeyPath{"author"}}, record, []Key{{"ann"}}},
+		{Index{KeyPath: KeyPath{"missing"}}, record, []Key{}},
+		{Index{KeyPath: KeyPath{"tags"}, MultiEntry: true}, map[string]interface{}{"tags": []interface{}{"a", "b", "a"}}, []Key{{"a"}, {"b"}}},
+		{Index{KeyPath: KeyPath{"tags"}, MultiEntry: true}, record, []Key{{"a"}, {"b"}}},
+		{Index{KeyPath: KeyPath{"author"}, MultiEntry: true}, record, []Key{{"ann"}}},
+		{Index{Name: "custom"}, indexed{Author: "ann"}, []Key{{"ann", 1.0}}},
+		{Index{KeyPath: KeyPath{"author", "tags"}}, map[string]interface{}{"author": "ann", "tags": "a"}, []Key{{"ann", "a"}}},
+		{Index{KeyPath: KeyPath{"author", "missing"}}, record, []Key{}},
+		{Index{KeyPath: KeyPath{"meta.author"}}, map[string]interface{}{"meta": record}, []Key{{"ann"}}},
+		{Index{KeyPath: KeyPath{"author"}}, json.RawMessage(`{"author":"ann"}`), []Key{{"ann"}}},
+		{Index{KeyPath: KeyPath{"tags"}, MultiEntry: true}, json.RawMessage(`{"tags":["a","b"]}`), []Key{{"a"}, {"b"}}},
+		{Index{KeyPath: KeyPath{"author"}}, json.RawMessage(`{"tags":[]}`), []Key{}},
+		{Index{KeyPath: KeyPath{"author"}}, map[string]string{"author": "ann"}, []Key{{"ann"}}},
+	} {
+		keys := c.idx.Keys(c.record)
+		if !reflect.DeepEqual(keys, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.idx.KeyPath, c.expected, keys)
+		}
+	}
+}
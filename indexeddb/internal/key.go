@@ -1,10 +1,7 @@
 package internal
 
 import (
-	"fmt"
-
 	"github.com/huffduff/go-indexeddb/bytewise"
-	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 type Key []interface{}
@@ -21,7 +18,7 @@ func rawKey(src []byte) ([]interface{}, error) {
 	}
 	raw, ok := data.([]interface{})
 	if !ok {
-		err = fmt.Errorf("not a valid key")
+		err = NewError(DataError, "not a valid key")
 	}
 	return raw, err
 }
@@ -33,14 +30,16 @@ func fromCore(src []byte) (Key, error) {
 	}
 	coerced := data.([]interface{})
 	if coerced[0] != "core" {
-		return nil, fmt.Errorf("key is not a valid core key")
+		return nil, NewError(DataError, "key is not a valid core key")
 	}
 	var p Key = coerced[1:]
 	return p, nil
 }
 
+// forIndex encodes an index entry key. Index names are scoped by their store,
+// so the store name comes first.
 func (p Key) forIndex(i *Index, id interface{}) ([]byte, error) {
-	key := append([]interface{}{"idx", i.Name}, p...)
+	key := append([]interface{}{"idx", i.StoreName, i.Name}, p...)
 	if !i.Unique {
 		key = append(key, id)
 	}
@@ -53,18 +52,19 @@ func fromIndex(i *Index, src []byte) (string, Key, error) {
 		return "", nil, err
 	}
 	coerced := data.([]interface{})
-	if coerced[0] != "idx" {
-		return "", nil, fmt.Errorf("key is not a valid index key")
+	if len(coerced) < 3 || coerced[0] != "idx" {
+		return "", nil, NewError(DataError, "key is not a valid index key")
 	}
-	name, ok := coerced[1].(string)
+	name, ok := coerced[2].(string)
 	if !ok {
-		return name, nil, fmt.Errorf("key does not contain a valid index name")
+		return name, nil, NewError(DataError, "key does not contain a valid index name")
 	}
 	last := len(coerced)
-	if i.Unique {
-		last = -1
+	if !i.Unique {
+		// the primary key follows the index key
+		last--
 	}
-	var p Key = coerced[2:last]
+	var p Key = coerced[3:last]
 	return name, p, nil
 }
 
@@ -80,11 +80,11 @@ func fromStore(src []byte) (string, Key, error) {
 	}
 	coerced := data.([]interface{})
 	if coerced[0] != "data" {
-		return "", nil, fmt.Errorf("key is not a valid index key")
+		return "", nil, NewError(DataError, "key is not a valid index key")
 	}
 	name, ok := coerced[1].(string)
 	if !ok {
-		return name, nil, fmt.Errorf("key does not contain a valid store name")
+		return name, nil, NewError(DataError, "key does not contain a valid store name")
 	}
 	var p Key = coerced[2:]
 	return name, p, nil
@@ -114,77 +114,3 @@ done:
 	}
 	return out
 }
-
-type Range struct {
-	Start          *Key
-	Limit          *Key
-	StartExclusive bool
-	LimitInclusive bool
-	Prefix         bool
-}
-
-func (p Range) prep() Range {
-	var start = Key{}
-	if p.Start != nil {
-		start = *p.Start
-	}
-	if p.StartExclusive {
-		start = start.Next()
-	}
-
-	var limit = Key{}
-	if p.Prefix {
-		limit = start.Stop()
-	} else if p.Limit != nil {
-		limit := *p.Limit
-		if p.LimitInclusive {
-			limit = limit.Next()
-		}
-	}
-	return Range{Start: &start, Limit: &limit}
-}
-
-func (p Range) forStore(s *Store) (util.Range, error) {
-	out := util.Range{}
-	var err error
-
-	fixed := p.prep()
-
-	out.Start, err = fixed.Start.forStore(s)
-	if err != nil {
-		return out, err
-	}
-
-	out.Limit, err = fixed.Limit.forStore(s)
-	if err != nil {
-		return out, err
-	}
-	return out, nil
-}
-
-func (p Range) forIndex(i *Index) (util.Range, error) {
-	out := util.Range{}
-	var err error
-
-	fixed := p.prep()
-
-	out.Start, err = fixed.Start.forIndex(i, nil)
-	if err != nil {
-		return out, err
-	}
-
-	out.Limit, err = fixed.Limit.forIndex(i, nil)
-	if err != nil {
-		return out, err
-	}
-	return out, nil
-}
-
-func (p Range) forCore() *util.Range {
-	fixed := p.prep()
-
-	return &util.Range{
-		Start: fixed.Start.forCore(),
-		Limit: fixed.Limit.forCore(),
-	}
-}
@@ -13,10 +13,10 @@ var ordered []Key = []Key{
 	{"core", "index", "foo"},
 	{"core", "store", "bar"},
 	{"core", "store", "foo"},
-	{"idx", "bar", "a"},
-	{"idx", "bar", "b"},
-	{"idx", "foo", 3.0, "a"},
-	{"idx", "foo", 3.0, "b"},
+	{"idx", "bar", "foo", "a"},
+	{"idx", "bar", "foo", "b"},
+	{"idx", "foo", "bar", 3.0, "a"},
+	{"idx", "foo", "bar", 3.0, "b"},
 	{"data", "bar", "record"},
 	{"data", "bar", "record 1"},
 	{"data", "bar", "record 2"},
@@ -54,12 +54,12 @@ func TestForStore(t *testing.T) {
 }
 
 func TestForUniqueIndex(t *testing.T) {
-	idx := &Index{Name: "foo", Unique: true}
+	idx := &Index{Name: "foo", StoreName: "bar", Unique: true}
 	k, err := Key{3.0}.forIndex(idx, nil)
 	if err != nil {
 		t.Error(err)
 	}
-	if !bytes.Equal(k, bytewise.MustEncode("idx", "foo", 3.0)) {
+	if !bytes.Equal(k, bytewise.MustEncode("idx", "bar", "foo", 3.0)) {
 		t.Error("keys do not match")
 	}
 
@@ -67,18 +67,18 @@ func TestForUniqueIndex(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if !bytes.Equal(k, bytewise.MustEncode("idx", "foo", 3.0)) {
+	if !bytes.Equal(k, bytewise.MustEncode("idx", "bar", "foo", 3.0)) {
 		t.Error("keys do not match")
 	}
 }
 
 func TestForNonUniqueIndex(t *testing.T) {
-	idx := &Index{Name: "foo", Unique: false}
+	idx := &Index{Name: "foo", StoreName: "bar", Unique: false}
 	k1, err := Key{3.0}.forIndex(idx, nil)
 	if err != nil {
 		t.Error(err)
 	}
-	if !bytes.Equal(k1, bytewise.MustEncode("idx", "foo", 3.0, nil)) {
+	if !bytes.Equal(k1, bytewise.MustEncode("idx", "bar", "foo", 3.0, nil)) {
 		t.Error("keys do not match")
 	}
 
@@ -86,7 +86,7 @@ func TestForNonUniqueIndex(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if !bytes.Equal(k2, bytewise.MustEncode("idx", "foo", 3.0, "some id")) {
+	if !bytes.Equal(k2, bytewise.MustEncode("idx", "bar", "foo", 3.0, "some id")) {
 		t.Error("keys do not match")
 	}
 
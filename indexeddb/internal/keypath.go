@@ -0,0 +1,327 @@
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// KeyPath locates the key of a record. A single path yields a simple key, several
+// paths yield a compound key with one component per path. Paths reach nested
+// properties with dots, e.g. "author.id". It is stored as a JSON string for a
+// single path and as an array of strings otherwise.
+type KeyPath []string
+
+func (p KeyPath) String() string {
+	if len(p) == 1 {
+		return p[0]
+	}
+	return "[" + strings.Join(p, ", ") + "]"
+}
+
+// Compound reports whether the key path yields compound keys
+func (p KeyPath) Compound() bool {
+	return len(p) > 1
+}
+
+func (p KeyPath) MarshalJSON() ([]byte, error) {
+	if len(p) == 1 {
+		return json.Marshal(p[0])
+	}
+	return json.Marshal([]string(p))
+}
+
+func (p *KeyPath) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*p = nil
+		if path != "" {
+			*p = KeyPath{path}
+		}
+		return nil
+	}
+	var paths []string
+	err := json.Unmarshal(data, &paths)
+	if err != nil {
+		return err
+	}
+	*p = paths
+	return nil
+}
+
+// keyFor evaluates the key path against value. The second result is false when
+// one of the paths does not exist on the value.
+func (p KeyPath) keyFor(value interface{}) (Key, bool, error) {
+	// decode raw JSON once rather than for every path
+	value = decodeRaw(value)
+	key := make(Key, 0, len(p))
+	for _, path := range p {
+		raw, ok := evaluateKeyPath(value, path)
+		if !ok {
+			return nil, false, nil
+		}
+		k, err := toKey(raw)
+		if err != nil {
+			return nil, true, NewError(DataError, "key path %s does not yield a valid key: %w", path, err)
+		}
+		key = append(key, k)
+	}
+	return key, true, nil
+}
+
+// evaluateKeyPath resolves a dotted path against value. Struct fields are matched by
+// their json name, falling back to the field name when untagged. Maps with string keys
+// are looked up directly and raw JSON is decoded first. The second result is false
+// when the path does not exist on the value.
+func evaluateKeyPath(value interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return decodeRaw(value), true
+	}
+	for _, name := range strings.Split(path, ".") {
+		var ok bool
+		value, ok = property(decodeRaw(value), name)
+		if !ok {
+			return nil, false
+		}
+	}
+	return decodeRaw(value), true
+}
+
+// property looks up a single property of value
+func property(value interface{}, name string) (interface{}, bool) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		f, ok := fieldByJSONName(v, name)
+		if !ok {
+			return nil, false
+		}
+		return f.Interface(), true
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		out := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		if !out.IsValid() {
+			return nil, false
+		}
+		return out.Interface(), true
+	}
+	return nil, false
+}
+
+// decodeRaw decodes values holding raw JSON so their properties can be looked up.
+// Invalid JSON decodes to nil, which has no properties.
+func decodeRaw(value interface{}) interface{} {
+	var data []byte
+	switch v := value.(type) {
+	case json.RawMessage:
+		data = v
+	case *json.RawMessage:
+		if v == nil {
+			return nil
+		}
+		data = *v
+	default:
+		return value
+	}
+	var out interface{}
+	if json.Unmarshal(data, &out) != nil {
+		return nil
+	}
+	return out
+}
+
+// fieldByJSONName finds the exported field that encoding/json would use for name.
+// Fields of embedded structs without a json name are promoted like encoding/json does.
+func fieldByJSONName(v reflect.Value, name string) (reflect.Value, bool) {
+	f, _, ok := lookupJSONField(v, name)
+	return f, ok
+}
+
+// lookupJSONField is fieldByJSONName, also reporting whether the field is tagged omitempty
+func lookupJSONField(v reflect.Value, name string) (reflect.Value, bool, bool) {
+	t := v.Type()
+	var embedded []reflect.Value
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		tagName := opts[0]
+		if f.Anonymous && tagName == "" {
+			fv := v.Field(i)
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				embedded = append(embedded, fv)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if tagName == name || (tagName == "" && f.Name == name) {
+			omitEmpty := false
+			for _, opt := range opts[1:] {
+				omitEmpty = omitEmpty || opt == "omitempty"
+			}
+			return v.Field(i), omitEmpty, true
+		}
+	}
+	for _, fv := range embedded {
+		if f, omitEmpty, ok := lookupJSONField(fv, name); ok {
+			return f, omitEmpty, true
+		}
+	}
+	return reflect.Value{}, false, false
+}
+
+// keyPathMissing reports whether value holds no key at path for a key generator:
+// the property is absent, nil or the number 0, which generated keys never take.
+// Struct fields tagged omitempty are absent while empty, as they are from the
+// value's JSON form. Other zero values, such as "", are keys.
+func keyPathMissing(value interface{}, path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		v := reflect.ValueOf(decodeRaw(value))
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return true
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			var ok bool
+			value, ok = property(v.Interface(), name)
+			if !ok {
+				return true
+			}
+			continue
+		}
+		f, omitEmpty, ok := lookupJSONField(v, name)
+		if !ok || (omitEmpty && isEmptyValue(f)) {
+			return true
+		}
+		value = f.Interface()
+	}
+	v := reflect.ValueOf(decodeRaw(value))
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return v.IsZero()
+	}
+	return false
+}
+
+// toKey converts a value extracted from a record into a valid key component.
+// Numbers become float64, dates are normalized to UTC and slices are converted
+// element by element.
+func toKey(value interface{}) (interface{}, error) {
+	switch t := value.(type) {
+	case string:
+		return t, nil
+	case float64:
+		if math.IsNaN(t) {
+			return nil, NewError(DataError, "NaN is not a valid key")
+		}
+		return t, nil
+	case time.Time:
+		return t.UTC(), nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32:
+		return toKey(v.Float())
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice, reflect.Array:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			k, err := toKey(v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			out[i] = k
+		}
+		return out, nil
+	}
+	return nil, NewError(DataError, "%T is not a valid key", value)
+}
+
+// keyForValue determines the primary key for value using the store's key path.
+// Stores with a key generator take the next generated key when the value does not
+// carry one (see keyPathMissing), writing it back into the value when the store has
+// a key path.
+func (p *Store) keyForValue(w *bulkWriter, value interface{}) (Key, error) {
+	if len(p.KeyPath) == 0 {
+		if p.AutoIncrement {
+			return p.generateKey(w)
+		}
+		return nil, NewError(DataError, "store %s has no key path or key generator, a key must be provided", p.Name)
+	}
+
+	// key generators are only allowed with a single key path
+	if p.AutoIncrement {
+		if keyPathMissing(value, p.KeyPath[0]) {
+			key, err := p.generateKey(w)
+			if err != nil {
+				return nil, err
+			}
+			err = injectKey(value, p.KeyPath[0], key[0].(float64))
+			if err != nil {
+				return nil, err
+			}
+			return key, nil
+		}
+	}
+
+	key, ok, err := p.KeyPath.keyFor(value)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, NewError(DataError, "key path %s not found on value", p.KeyPath)
+	}
+	return key, nil
+}
+
+// isEmptyValue reports whether encoding/json omits v from a field tagged omitempty
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
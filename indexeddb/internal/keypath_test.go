@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEvaluateKeyPath(t *testing.T) {
+	type record struct {
+		Id      string `json:"_id"`
+		Title   string
+		Skipped string `json:"-"`
+	}
+	r := record{"a", "title", "skipped"}
+
+	for path, expected := range map[string]interface{}{"_id": "a", "Title": "title"} {
+		if v, ok := evaluateKeyPath(&r, path); !ok || v != expected {
+			t.Errorf("%s: expected %v, got %v", path, expected, v)
+		}
+	}
+	for _, path := range []string{"Id", "Skipped", "missing"} {
+		if _, ok := evaluateKeyPath(r, path); ok {
+			t.Errorf("%s should not resolve", path)
+		}
+	}
+	if v, ok := evaluateKeyPath(map[string]interface{}{"_id": 1.0}, "_id"); !ok || v != 1.0 {
+		t.Errorf("expected map lookup to resolve, got %v", v)
+	}
+
+	type author struct {
+		Id string `json:"id"`
+	}
+	nested := struct {
+		Author *author `json:"author"`
+	}{&author{"ann"}}
+	if v, ok := evaluateKeyPath(nested, "author.id"); !ok || v != "ann" {
+		t.Errorf("expected nested field to resolve, got %v", v)
+	}
+	if v, ok := evaluateKeyPath(map[string]interface{}{"author": map[string]interface{}{"id": "bob"}}, "author.id"); !ok || v != "bob" {
+		t.Errorf("expected nested map to resolve, got %v", v)
+	}
+	if _, ok := evaluateKeyPath(nested, "author.id.x"); ok {
+		t.Error("a path through a key should not resolve")
+	}
+}
+
+func TestEvaluateKeyPathSchemaless(t *testing.T) {
+	raw := json.RawMessage(`{"author":{"id":"ann"},"tags":["a"]}`)
+	wrapped := struct {
+		Doc json.RawMessage `json:"doc"`
+	}{raw}
+
+	for _, c := range []struct {
+		value    interface{}
+		path     string
+		expected interface{}
+	}{
+		{raw, "author.id", "ann"},
+		{&raw, "author.id", "ann"},
+		{raw, "tags", []interface{}{"a"}},
+		{wrapped, "doc.author.id", "ann"},
+		{map[string]string{"id": "bob"}, "id", "bob"},
+		{map[string]map[string]int{"meta": {"n": 2}}, "meta.n", 2},
+		{json.RawMessage(`{"n":1}`), "", map[string]interface{}{"n": 1.0}},
+	} {
+		v, ok := evaluateKeyPath(c.value, c.path)
+		if !ok || !reflect.DeepEqual(v, c.expected) {
+			t.Errorf("%s: expected %v, got %v %v", c.path, c.expected, v, ok)
+		}
+	}
+
+	for _, value := range []interface{}{
+		json.RawMessage(`{"other":1}`),
+		json.RawMessage(`not json`),
+		map[int]string{1: "a"},
+		(*json.RawMessage)(nil),
+	} {
+		if _, ok := evaluateKeyPath(value, "id"); ok {
+			t.Errorf("%v should not resolve", value)
+		}
+	}
+}
+
+func TestKeyPathJSON(t *testing.T) {
+	for in, expected := range map[string]KeyPath{
+		`""`:        nil,
+		`"a.b"`:     {"a.b"},
+		`["a","b"]`: {"a", "b"},
+	} {
+		var p KeyPath
+		if err := json.Unmarshal([]byte(in), &p); err != nil || !reflect.DeepEqual(p, expected) {
+			t.Errorf("%s: expected %v, got %v %v", in, expected, p, err)
+		}
+		if len(expected) == 0 {
+			continue
+		}
+		out, err := json.Marshal(p)
+		if err != nil || string(out) != in {
+			t.Errorf("%v: expected %s, got %s %v", p, in, out, err)
+		}
+	}
+}
+
+func TestInjectKey(t *testing.T) {
+	type inner struct {
+		Id int `json:"id"`
+	}
+	v := &struct {
+		Meta inner `json:"meta"`
+	}{}
+	if err := injectKey(v, "meta.id", 3); err != nil || v.Meta.Id != 3 {
+		t.Errorf("expected nested field to be set, got %v %v", v.Meta.Id, err)
+	}
+
+	m := map[string]interface{}{"meta": map[string]interface{}{}}
+	if err := injectKey(m, "meta.id", 4); err != nil || m["meta"].(map[string]interface{})["id"] != 4.0 {
+		t.Errorf("expected nested map to be set, got %v %v", m, err)
+	}
+	if err := injectKey(m, "missing.id", 5); err == nil {
+		t.Error("expected a missing parent to fail")
+	}
+}
+
+func TestKeyPathMissing(t *testing.T) {
+	type value struct {
+		Id    int    `json:"id"`
+		Opt   int    `json:"opt,omitempty"`
+		Ptr   *int   `json:"ptr"`
+		Label string `json:"label"`
+		Any   interface{}
+	}
+	zero := 0
+	for path, expected := range map[string]bool{"id": true, "opt": true, "ptr": true, "label": false, "Any": true, "missing": true} {
+		if missing := keyPathMissing(&value{}, path); missing != expected {
+			t.Errorf("%s: expected missing to be %v", path, expected)
+		}
+	}
+	if keyPathMissing(value{Id: 1}, "id") || keyPathMissing(value{Opt: 2, Ptr: &zero}, "opt") || keyPathMissing(value{Ptr: &zero}, "ptr") {
+		t.Error("expected set fields to be present")
+	}
+	m := map[string]interface{}{"id": 0.0, "name": "", "none": nil}
+	for path, expected := range map[string]bool{"id": true, "name": false, "none": true, "missing": true} {
+		if missing := keyPathMissing(m, path); missing != expected {
+			t.Errorf("%s: expected missing to be %v", path, expected)
+		}
+	}
+}
+
+func TestToKey(t *testing.T) {
+	date := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.FixedZone("x", 3600))
+	for in, expected := range map[interface{}]interface{}{
+		"a":      "a",
+		3:        3.0,
+		uint8(2): 2.0,
+		2.5:      2.5,
+		date:     date.UTC(),
+	} {
+		k, err := toKey(in)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(k, expected) {
+			t.Errorf("expected %v, got %v", expected, k)
+		}
+	}
+
+	k, err := toKey([]int{1, 2})
+	if err != nil || !reflect.DeepEqual(k, []interface{}{1.0, 2.0}) {
+		t.Errorf("unexpected array key %v %v", k, err)
+	}
+
+	for _, in := range []interface{}{true, nil, struct{}{}, map[string]interface{}{}} {
+		if _, err := toKey(in); err == nil {
+			t.Errorf("%v should not be a valid key", in)
+		}
+	}
+}
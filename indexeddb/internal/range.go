@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"github.com/huffduff/go-indexeddb/bytewise"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+type Range struct {
+	Start          *Key
+	Limit          *Key
+	StartExclusive bool
+	LimitInclusive bool
+	Prefix         bool
+}
+
+// encodePrefix encodes parts as an unterminated array. The result is a byte prefix of the
+// encoding of every key that extends parts, and sorts before all of them.
+func encodePrefix(parts []interface{}) ([]byte, error) {
+	b, err := bytewise.Encode(parts)
+	if err != nil {
+		return nil, err
+	}
+	return b[:len(b)-1], nil
+}
+
+const (
+	// afterKey sorts directly after a complete key, which always ends with END
+	afterKey byte = 0x01
+	// afterPrefix sorts after every key extending a prefix, as no token starts with 0xff
+	afterPrefix byte = 0xff
+)
+
+// encode converts the range into byte bounds within the keyspace ns. after is appended to
+// inclusive limits and exclusive starts to step past the key they name.
+func (p Range) encode(ns []interface{}, after byte) (util.Range, error) {
+	out := util.Range{}
+
+	join := func(k Key) []interface{} {
+		parts := make([]interface{}, 0, len(ns)+len(k))
+		parts = append(parts, ns...)
+		return append(parts, k...)
+	}
+
+	base, err := encodePrefix(ns)
+	if err != nil {
+		return out, err
+	}
+
+	out.Start = base
+	if p.Start != nil {
+		out.Start, err = encodePrefix(join(*p.Start))
+		if err != nil {
+			return out, err
+		}
+		if p.StartExclusive {
+			out.Start = append(out.Start, after)
+		}
+	}
+
+	switch {
+	case p.Prefix && p.Start != nil:
+		out.Limit, err = encodePrefix(join(*p.Start))
+		if err != nil {
+			return out, err
+		}
+		out.Limit = append(out.Limit, afterPrefix)
+	case p.Limit != nil:
+		out.Limit, err = encodePrefix(join(*p.Limit))
+		if err != nil {
+			return out, err
+		}
+		if p.LimitInclusive {
+			out.Limit = append(out.Limit, after)
+		}
+	default:
+		out.Limit = append(base[:len(base):len(base)], afterPrefix)
+	}
+	return out, nil
+}
+
+// Only generates a range with an exact match
+func Only(key Key) Range {
+	return Range{
+		Start:          &key,
+		Limit:          &key,
+		LimitInclusive: true,
+	}
+}
+
+func (p Range) forStore(s *Store) (util.Range, error) {
+	return p.encode([]interface{}{"data", s.Name}, afterKey)
+}
+
+func (p Range) forIndex(i *Index) (util.Range, error) {
+	after := afterKey
+	if !i.Unique {
+		// entries carry the primary key after the index key
+		after = afterPrefix
+	}
+	return p.encode([]interface{}{"idx", i.StoreName, i.Name}, after)
+}
+
+func (p Range) forCore() (util.Range, error) {
+	return p.encode([]interface{}{"core"}, afterKey)
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func inRange(r util.Range, k []byte) bool {
+	return bytes.Compare(r.Start, k) <= 0 && bytes.Compare(k, r.Limit) < 0
+}
+
+func TestRangeForStore(t *testing.T) {
+	store := &Store{Name: "foo"}
+	a, _ := Key{"a"}.forStore(store)
+	ab, _ := Key{"a", "b"}.forStore(store)
+	b, _ := Key{"b"}.forStore(store)
+	other, _ := Key{"a"}.forStore(&Store{Name: "foo2"})
+
+	all, err := Range{}.forStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range [][]byte{a, ab, b} {
+		if !inRange(all, k) {
+			t.Errorf("%v should be within the full range", k)
+		}
+	}
+	if inRange(all, other) {
+		t.Error("keys from other stores should not be in range")
+	}
+
+	start, limit := Key{"a"}, Key{"b"}
+	exact, _ := Range{Start: &start, Limit: &start, LimitInclusive: true}.forStore(store)
+	if !inRange(exact, a) || inRange(exact, ab) || inRange(exact, b) {
+		t.Error("inclusive bounds should only match the exact key")
+	}
+	open, _ := Range{Start: &start, StartExclusive: true, Limit: &limit}.forStore(store)
+	if inRange(open, a) || !inRange(open, ab) || inRange(open, b) {
+		t.Error("exclusive bounds should skip the named keys")
+	}
+	prefix, _ := Range{Start: &start, Prefix: true}.forStore(store)
+	if !inRange(prefix, a) || !inRange(prefix, ab) || inRange(prefix, b) {
+		t.Error("prefix ranges should match keys extending the prefix")
+	}
+}
+
+func TestRangeForCoreError(t *testing.T) {
+	if _, err := (Range{Start: &Key{make(chan int)}}).forCore(); err == nil {
+		t.Error("expected a key that cannot be encoded to fail")
+	}
+}
@@ -1,8 +1,185 @@
 package internal
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
 
 type Record struct {
 	IndexKeys map[string][][]byte `json:"indexKeys"`
-	Value     json.RawMessage     `json:"value"`
+	// Value holds values encoded by the JSON codec
+	Value json.RawMessage `json:"value,omitempty"`
+	// Data holds values encoded by any other codec
+	Data []byte `json:"data,omitempty"`
+}
+
+// encode stores value in the record using c
+func (r *Record) encode(c Codec, value interface{}) error {
+	data, err := c.Marshal(value)
+	if err != nil {
+		return NewError(DataError, "cannot encode value with codec %s: %w", c.Name(), err)
+	}
+	if c == JSONCodec {
+		r.Value = data
+	} else {
+		r.Data = data
+	}
+	return nil
+}
+
+// decode reads the record's value into v using c
+func (r *Record) decode(c Codec, v interface{}) error {
+	data := r.Data
+	if c == JSONCodec {
+		data = r.Value
+	}
+	return c.Unmarshal(data, v)
+}
+
+// recordBinary starts records stored in the binary envelope.
+// JSON envelopes always start with '{'.
+const recordBinary byte = 1
+
+// marshal encodes the record for storage. Values of the JSON codec are kept in a
+// JSON envelope, those of other codecs in a binary one so their data is not base64'd.
+func (r *Record) marshal() []byte {
+	if r.Value != nil {
+		out, _ := json.Marshal(r)
+		return out
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(recordBinary)
+	names := make([]string, 0, len(r.IndexKeys))
+	for name := range r.IndexKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeBinaryLength(&buf, len(names))
+	for _, name := range names {
+		writeBinaryString(&buf, name)
+		writeBinaryLength(&buf, len(r.IndexKeys[name]))
+		for _, k := range r.IndexKeys[name] {
+			writeBinaryLength(&buf, len(k))
+			buf.Write(k)
+		}
+	}
+	buf.Write(r.Data)
+	return buf.Bytes()
+}
+
+// unmarshalRecord decodes a stored record of either envelope
+func unmarshalRecord(data []byte) (Record, error) {
+	var record Record
+	if len(data) == 0 || data[0] != recordBinary {
+		err := json.Unmarshal(data, &record)
+		return record, err
+	}
+
+	r := bytes.NewReader(data[1:])
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return record, err
+	}
+	if n > uint64(r.Len()) {
+		return record, io.ErrUnexpectedEOF
+	}
+	record.IndexKeys = make(map[string][][]byte, n)
+	for i := uint64(0); i < n; i++ {
+		name, err := readBinaryString(r)
+		if err != nil {
+			return record, err
+		}
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return record, err
+		}
+		if count > uint64(r.Len()) {
+			return record, io.ErrUnexpectedEOF
+		}
+		keys := make([][]byte, count)
+		for j := range keys {
+			k, err := readBinaryString(r)
+			if err != nil {
+				return record, err
+			}
+			keys[j] = []byte(k)
+		}
+		record.IndexKeys[name] = keys
+	}
+	record.Data = append([]byte{}, data[len(data)-r.Len():]...)
+	return record, nil
+}
+
+// decodeValue decodes the record's value so key paths can be evaluated against it
+func (r *Record) decodeValue(c Codec) (interface{}, error) {
+	if t, ok := c.(TypedCodec); ok {
+		v := t.New()
+		err := r.decode(c, v)
+		return v, err
+	}
+	var value interface{}
+	err := r.decode(c, &value)
+	return value, err
+}
+
+// decodeRecord reads the value of a stored record into v using c
+func decodeRecord(c Codec, data []byte, v interface{}) error {
+	record, err := unmarshalRecord(data)
+	if err != nil {
+		return err
+	}
+	return record.decode(c, v)
+}
+
+// GetAllOptions selects the records returned by GetAllRecords
+type GetAllOptions struct {
+	// Query limits the records to a key range, every record when empty
+	Query Range
+	// Count is the most records returned, 0 returns every record
+	Count int
+	// Offset skips that many records in Direction before the first one returned
+	Offset int
+	// Direction orders the records, NEXT when empty
+	Direction Direction
+}
+
+// Entry is one record returned by GetAllRecords. For indexes Key is the index key,
+// for stores it is the primary key.
+type Entry struct {
+	Key        Key
+	PrimaryKey Key
+
+	data  []byte
+	codec Codec
+}
+
+// Value decodes the record's value into v
+func (e *Entry) Value(v interface{}) error {
+	return decodeRecord(e.codec, e.data, v)
+}
+
+// getAllEntries walks c according to opts, reading each entry with read
+func getAllEntries(c KeyCursor, iter iterator.Iterator, opts GetAllOptions, read func() (Entry, error)) ([]Entry, error) {
+	defer iter.Release()
+	if opts.Count < 0 || opts.Offset < 0 {
+		return nil, NewError(DataError, "count and offset cannot be negative")
+	}
+
+	out := make([]Entry, 0)
+	if opts.Offset > 0 && !c.Advance(opts.Offset) {
+		return out, iter.Error()
+	}
+	for (opts.Count == 0 || len(out) < opts.Count) && c.Continue() {
+		entry, err := read()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, entry)
+	}
+	return out, iter.Error()
 }
@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"reflect"
 
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -13,10 +13,14 @@ import (
 type Store struct {
 	*Database
 
-	Name          string            `json:"name"`
-	KeyPath       string            `json:"keyPath,omitempty"`
-	AutoIncrement bool              `json:"autoIncrement"`
-	Indexes       map[string]*Index `json:"-"`
+	Name          string  `json:"name"`
+	KeyPath       KeyPath `json:"keyPath,omitempty"`
+	AutoIncrement bool    `json:"autoIncrement"`
+	// Codec names the codec of the store's values, empty for JSON
+	Codec   string            `json:"codec,omitempty"`
+	Indexes map[string]*Index `json:"-"`
+
+	codec Codec
 }
 
 func (p *Store) IndexNames() []string {
@@ -27,126 +31,146 @@ func (p *Store) IndexNames() []string {
 	return keys
 }
 
-func (p *Store) put(tr *leveldb.Transaction, primaryKey []byte, existingIdx map[string][][]byte, value interface{}) error {
+// put adds the writes storing value under key to w.
+// Unique indexes are checked against the transaction and the earlier writes of w.
+// Nothing is written when an error is returned.
+func (p *Store) put(w *bulkWriter, key Key, primaryKey []byte, existingIdx map[string][][]byte, value interface{}) error {
 	var err error
 
-	b := &leveldb.Batch{}
-
-	record := Record{}
+	record := Record{IndexKeys: make(map[string][][]byte, len(p.Indexes))}
 
-	// idxKeys := make([]Key, 0)
-	// get a reflected version once
-	// ref := reflect.ValueOf(value)
-	for idxName := range p.Indexes {
-		idx := p.Indexes[idxName]
-
-		var erase [][]byte
-		copy(erase, existingIdx[idxName])
-
-		keys := idx.Keys(primaryKey, value)
-
-		record.IndexKeys[idxName] = make([][]byte, len(keys))
-
-		for i, key := range keys {
-			k, _ := key.forIndex(idx, key)
-			b.Put(k, primaryKey)
-			record.IndexKeys[idxName][i] = k
-			// scrub from the erase list
-			i := 0
-			for _, h := range erase {
-				if bytes.Equal(h, k) {
-					erase[i] = h
-					i++
-					break
+	for idxName, idx := range p.Indexes {
+		entries, err := idx.entries(key, value)
+		if err != nil {
+			return err
+		}
+		if idx.Unique {
+			for _, k := range entries {
+				owner, err := w.get(k)
+				if errors.Is(err, leveldb.ErrNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(owner, primaryKey) {
+					return NewError(ConstraintError, "unique index %s already has an entry for the key of record %v", idx.Name, key)
 				}
 			}
-			erase = erase[:i]
 		}
-		for _, e := range erase {
-			b.Delete(e)
+		if len(entries) > 0 {
+			record.IndexKeys[idxName] = entries
 		}
 	}
-	record.Value, err = json.Marshal(value)
+	err = record.encode(p.codec, value)
 	if err != nil {
 		return err
 	}
-	val, _ := json.Marshal(record)
+	val := record.marshal()
 
-	b.Put(primaryKey, val)
+	for idxName := range p.Indexes {
+		entries := record.IndexKeys[idxName]
+		// drop the entries the new value no longer produces
+	stale:
+		for _, old := range existingIdx[idxName] {
+			for _, k := range entries {
+				if bytes.Equal(old, k) {
+					continue stale
+				}
+			}
+			w.remove(old)
+		}
+		for _, k := range entries {
+			w.set(k, primaryKey)
+		}
+	}
+	w.set(primaryKey, val)
 
-	return tr.Write(b, nil)
+	return nil
 }
 
-func (p *Store) Put(tr *leveldb.Transaction, key Key, value interface{}) error {
-	primaryKey, err := key.forStore(p)
+func (p *Store) Put(tr *Transaction, key Key, value interface{}) error {
+	w := p.newBulkWriter(tr)
+	err := w.put(key, value, false)
 	if err != nil {
 		return err
 	}
+	return w.flush()
+}
 
-	data, err := tr.Get(primaryKey, nil)
-	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+func (p *Store) Add(tr *Transaction, key Key, value interface{}) error {
+	w := p.newBulkWriter(tr)
+	err := w.put(key, value, true)
+	if err != nil {
 		return err
 	}
+	return w.flush()
+}
 
-	var record Record
-	json.Unmarshal(data, &record)
+// PutInline stores value under the key found at the store's key path,
+// or under a generated key for auto incrementing stores
+func (p *Store) PutInline(tr *Transaction, value interface{}) (Key, error) {
+	return p.putInline(tr, value, false)
+}
 
-	return p.put(tr, primaryKey, record.IndexKeys, value)
+// AddInline stores value under the key found at the store's key path,
+// or under a generated key for auto incrementing stores.
+// It fails if a record already exists for that key.
+func (p *Store) AddInline(tr *Transaction, value interface{}) (Key, error) {
+	return p.putInline(tr, value, true)
 }
 
-func (p *Store) Add(tr *leveldb.Transaction, key Key, value interface{}) error {
-	primaryKey, err := key.forStore(p)
+func (p *Store) putInline(tr *Transaction, value interface{}, add bool) (Key, error) {
+	w := p.newBulkWriter(tr)
+	key, err := p.keyForValue(w, value)
 	if err != nil {
-		return err
-	}
-
-	exists, err := tr.Has(primaryKey, nil)
-	if !errors.Is(err, leveldb.ErrNotFound) {
-		return err
+		return nil, err
 	}
-
-	if exists {
-		return fmt.Errorf("record already exists")
+	err = w.put(key, value, add)
+	if err != nil {
+		return nil, err
 	}
-
-	return p.put(tr, primaryKey, nil, value)
+	return key, w.flush()
 }
 
-func (p *Store) Delete(tr leveldb.Transaction, key Key) error {
-	primaryKey, err := key.forStore(p)
+// DeleteExact removes the record stored under key along with its index entries.
+// Deleting a key without a record is not an error.
+func (p *Store) DeleteExact(tr *Transaction, key Key) error {
+	w := p.newBulkWriter(tr)
+	err := w.delete(key)
 	if err != nil {
 		return err
 	}
+	return w.flush()
+}
 
-	ref, err := tr.Get(primaryKey, nil)
+// Delete removes every record within query along with its index entries
+func (p *Store) Delete(tr *Transaction, query Range) error {
+	q, err := query.forStore(p)
 	if err != nil {
 		return err
 	}
 
-	var record Record
-	json.Unmarshal(ref, &record)
-
-	b := &leveldb.Batch{}
-
-	for _, keys := range record.IndexKeys {
-		for _, key := range keys {
-			b.Delete(key)
+	w := p.newBulkWriter(tr)
+	iter := tr.NewIterator(&q, nil)
+	for iter.Next() {
+		err = w.deleteRecord(iter.Key(), iter.Value())
+		if err != nil {
+			iter.Release()
+			return err
 		}
 	}
-	b.Delete(primaryKey)
-
-	return tr.Write(b, nil)
+	err = iter.Error()
+	iter.Release()
+	if err != nil {
+		return err
+	}
+	return w.flush()
 }
 
-func (p *Store) Clear(r leveldb.Transaction) error {
-	q, _ := Range{Start: &Key{}, Prefix: true}.forStore(p)
-	b := &leveldb.Batch{}
-	iter := r.NewIterator(&q, nil)
-	for iter.Next() {
-		b.Delete(iter.Key())
-	}
-	iter.Release()
-	return p.Database.Write(b, nil)
+// Clear removes every record of the store
+func (p *Store) Clear(tr *Transaction) error {
+	return p.Delete(tr, Range{})
 }
 
 func (p *Store) GetExact(r leveldb.Reader, key Key, v interface{}) error {
@@ -160,13 +184,7 @@ func (p *Store) GetExact(r leveldb.Reader, key Key, v interface{}) error {
 		return err
 	}
 
-	var record Record
-	err = json.Unmarshal(data, &record)
-	if err != nil {
-		return err
-	}
-
-	return json.Unmarshal(record.Value, v)
+	return decodeRecord(p.codec, data, v)
 }
 
 func (p *Store) Get(r leveldb.Reader, query Range, v interface{}) error {
@@ -180,62 +198,116 @@ func (p *Store) Get(r leveldb.Reader, query Range, v interface{}) error {
 		return err
 	}
 
-	var record Record
-	err = json.Unmarshal(data, &record)
+	return decodeRecord(p.codec, data, v)
+}
+
+// Decoder decodes the value of the current record into v. It is only valid
+// until the callback it was passed to returns.
+type Decoder func(v interface{}) error
+
+// ForEach calls fn with the key of every record within query, in key order, and a
+// decoder for its value. Records are read one at a time, so memory use does not grow
+// with the size of the store. Iteration ends at the first error fn returns; ErrStop
+// ends it without an error.
+func (p *Store) ForEach(r leveldb.Reader, query Range, fn func(key Key, value Decoder) error) error {
+	q, err := query.forStore(p)
 	if err != nil {
 		return err
 	}
 
-	return json.Unmarshal(data, v)
+	iterErr := p.Database.GetIter(r, q, func(key, val []byte) bool {
+		var k Key
+		_, k, err = fromStore(key)
+		if err != nil {
+			return false
+		}
+		err = fn(k, func(v interface{}) error {
+			return decodeRecord(p.codec, val, v)
+		})
+		return err == nil
+	})
+	if err == ErrStop {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return iterErr
 }
 
+// GetAll decodes the values within query into v, which must point to a slice.
+// A limit of 0 returns every value.
 func (p *Store) GetAll(r leveldb.Reader, query Range, limit int, v interface{}) error {
-
-	q, err := query.forStore(p)
+	out, err := sliceOf(v)
 	if err != nil {
 		return err
 	}
 
-	// FIXME this is terribly inefficient
-	sizes, _ := p.Database.SizeOf([]util.Range{q})
-
-	out := bytes.NewBuffer(make([]byte, 0, sizes[0]))
-
 	i := 0
-
-	out.WriteByte('[')
-	p.Database.GetIter(r, q, func(key, val []byte) bool {
-		if i > 0 {
-			out.WriteByte(',')
+	return p.ForEach(r, query, func(_ Key, value Decoder) error {
+		err := appendDecoded(out, value)
+		if err != nil {
+			return err
 		}
-		out.Write(val)
 		i++
-		return i != limit
+		if i == limit {
+			return ErrStop
+		}
+		return nil
 	})
-	out.WriteByte(']')
-
-	return json.NewDecoder(out).Decode(v)
 }
 
-// FIXME this is terribly inefficient
+// GetMulti decodes the values stored under keys into v, which must point to a slice.
+// Keys without a record leave a zero value at their position.
 func (p *Store) GetMulti(r leveldb.Reader, keys []Key, v interface{}) error {
-	out := bytes.NewBuffer(make([]byte, 0, len(keys)*1024))
-
-	i := 0
+	out, err := sliceOf(v)
+	if err != nil {
+		return err
+	}
 
-	out.WriteByte('[')
 	for _, key := range keys {
-		if i > 0 {
-			out.WriteByte(',')
+		primaryKey, err := key.forStore(p)
+		if err != nil {
+			return err
+		}
+		val, err := p.Database.GetExact(r, primaryKey)
+		if errors.Is(err, leveldb.ErrNotFound) {
+			out.Set(reflect.Append(out, reflect.Zero(out.Type().Elem())))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = appendDecoded(out, func(v interface{}) error {
+			return decodeRecord(p.codec, val, v)
+		})
+		if err != nil {
+			return err
 		}
-		primaryKey, _ := key.forStore(p)
-		val, _ := p.Database.GetExact(r, primaryKey)
-		out.Write(val)
-		i++
 	}
-	out.WriteByte(']')
+	return nil
+}
 
-	return json.NewDecoder(out).Decode(v)
+// sliceOf empties the slice v points to so decoded values can be appended
+func sliceOf(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return reflect.Value{}, NewError(DataError, "%T is not a pointer to a slice", v)
+	}
+	out := rv.Elem()
+	out.Set(reflect.MakeSlice(out.Type(), 0, 0))
+	return out, nil
+}
+
+// appendDecoded decodes a value into a new element at the end of out
+func appendDecoded(out reflect.Value, decode Decoder) error {
+	elem := reflect.New(out.Type().Elem())
+	err := decode(elem.Interface())
+	if err != nil {
+		return err
+	}
+	out.Set(reflect.Append(out, elem.Elem()))
+	return nil
 }
 
 func (p *Store) GetKey(r leveldb.Reader, query Range) (Key, error) {
@@ -260,18 +332,21 @@ func (p *Store) GetAllKeys(r leveldb.Reader, query Range, limit int) ([]Key, err
 	}
 
 	keys := make([]Key, 0)
-	p.Database.GetIter(r, q, func(key, _ []byte) bool {
+	iterErr := p.Database.GetIter(r, q, func(key, _ []byte) bool {
 		_, val, e := fromStore(key)
 		if e != nil {
 			err = e
 			return false
 		}
 		keys = append(keys, val)
-		return true
+		return len(keys) != limit
 	})
 	if err != nil {
 		return nil, err
 	}
+	if iterErr != nil {
+		return nil, iterErr
+	}
 	return keys, nil
 }
 
@@ -289,9 +364,57 @@ func (p *Store) GetCursor(r leveldb.Reader, query Range, dir Direction) (*StoreC
 		return nil, err
 	}
 	iter := r.NewIterator(&q, nil)
-	return &StoreCursor{p, BaseCursor{iter: iter, direction: dir}}, nil
+	// cursors can only write within a read-write transaction
+	tr, _ := r.(*Transaction)
+	return &StoreCursor{store: p, tr: tr, BaseCursor: BaseCursor{iter: iter, direction: dir, bounds: q}}, nil
+}
+
+// ResumeCursor opens a cursor from a token made by a cursor of this store. Its
+// first step moves to the record after the one the token was made on.
+func (p *Store) ResumeCursor(r leveldb.Reader, token string) (*StoreCursor, error) {
+	t, err := p.Database.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if t.Store != p.Name || t.Index != "" {
+		return nil, NewError(DataError, "cursor token does not belong to store %s", p.Name)
+	}
+	q := util.Range{Start: t.Start, Limit: t.Limit}
+	iter := r.NewIterator(&q, nil)
+	tr, _ := r.(*Transaction)
+	return &StoreCursor{store: p, tr: tr, BaseCursor: BaseCursor{iter: iter, direction: t.Direction, bounds: q, resume: t.Position}}, nil
+}
+
+// GetAllRecords returns the key and value of the records selected by opts
+func (p *Store) GetAllRecords(r leveldb.Reader, opts GetAllOptions) ([]Entry, error) {
+	direction := opts.Direction
+	if direction == "" {
+		direction = NEXT
+	}
+	c, err := p.GetCursor(r, opts.Query, direction)
+	if err != nil {
+		return nil, err
+	}
+	return getAllEntries(c, c.iter, opts, func() (Entry, error) {
+		key, err := c.Key()
+		if err != nil {
+			return Entry{}, err
+		}
+		// the iterator reuses its buffer
+		data := append([]byte(nil), c.iter.Value()...)
+		return Entry{key, key, data, p.codec}, nil
+	})
 }
 
+// NewStore creates a store from its spec. The spec's codec must be registered.
 func NewStore(h *Database, spec Store) *Store {
-	return &Store{h, spec.Name, spec.KeyPath, spec.AutoIncrement, make(map[string]*Index)}
+	codec, _ := LookupCodec(spec.Codec)
+	return &Store{h, spec.Name, spec.KeyPath, spec.AutoIncrement, spec.Codec, make(map[string]*Index), codec}
+}
+
+// marshalSpec encodes the store spec without the database it belongs to
+func (p Store) marshalSpec() []byte {
+	p.Database = nil
+	val, _ := json.Marshal(p)
+	return val
 }
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// IndexesOf derives index definitions from the idb tags of a struct type. A tag
+// names the index a field belongs to, optionally followed by options:
+//
+//	Email string   `json:"email" idb:"byEmail,unique"`
+//	Tags  []string `json:"tags" idb:"byTag,multi"`
+//
+// The key path is the field's json name, dotted for fields of nested structs.
+// Fields tagged with the same index name form a compound key path in field order.
+// Options may be repeated on every field of the group, but must not differ.
+// Separate several indexes on one field with semicolons.
+func IndexesOf(t reflect.Type) ([]Index, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, NewError(DataError, "%s is not a struct", t)
+	}
+
+	out := make([]Index, 0)
+	positions := make(map[string]int)
+	// optioned holds the index names whose options were given by a field
+	optioned := make(map[string]bool)
+	err := walkIndexTags(t, "", make(map[reflect.Type]bool), func(path string, tag string) error {
+		opts := strings.Split(tag, ",")
+		name := strings.TrimSpace(opts[0])
+		if name == "" {
+			return NewError(DataError, "idb tag %q on %s has no index name", tag, path)
+		}
+		i, ok := positions[name]
+		if !ok {
+			i = len(out)
+			positions[name] = i
+			out = append(out, Index{Name: name})
+		}
+		out[i].KeyPath = append(out[i].KeyPath, path)
+		if len(opts) == 1 {
+			return nil
+		}
+		unique, multi := false, false
+		for _, opt := range opts[1:] {
+			switch strings.TrimSpace(opt) {
+			case "unique":
+				unique = true
+			case "multi":
+				multi = true
+			default:
+				return NewError(DataError, "unknown idb tag option %q on %s", opt, path)
+			}
+		}
+		if optioned[name] && (out[i].Unique != unique || out[i].MultiEntry != multi) {
+			return NewError(DataError, "idb tag on %s gives index %s different options than an earlier field", path, name)
+		}
+		optioned[name] = true
+		out[i].Unique, out[i].MultiEntry = unique, multi
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// walkIndexTags calls fn for every idb tag of t and the structs it contains.
+// Embedded structs without a json name are flattened like encoding/json does.
+func walkIndexTags(t reflect.Type, prefix string, visiting map[reflect.Type]bool, fn func(path string, tag string) error) error {
+	// recursive types can only be tagged down to the first repetition
+	if visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		embedded := f.Anonymous && ft.Kind() == reflect.Struct
+		if f.PkgPath != "" && !embedded {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		path := prefix
+		if !embedded || name != "" {
+			if name == "" {
+				name = f.Name
+			}
+			if prefix != "" {
+				path = prefix + "." + name
+			} else {
+				path = name
+			}
+		}
+
+		if tag := f.Tag.Get("idb"); tag != "" {
+			for _, spec := range strings.Split(tag, ";") {
+				err := fn(path, spec)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if ft.Kind() == reflect.Struct && ft != timeType {
+			err := walkIndexTags(ft, path, visiting, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
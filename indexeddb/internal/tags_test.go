@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type tagBase struct {
+	Tenant string `json:"tenant" idb:"byTenantCreated"`
+}
+
+type tagged struct {
+	tagBase
+	Id      string    `json:"_id"`
+	Email   string    `json:"email" idb:"byEmail,unique"`
+	Tags    []string  `json:"tags" idb:"byTag,multi"`
+	Created time.Time `json:"created" idb:"byTenantCreated;byCreated"`
+	Author  *struct {
+		Id string `json:"id" idb:"byAuthor"`
+	} `json:"author"`
+	Next   *tagged `json:"next"`
+	hidden string  `idb:"byHidden"`
+}
+
+func TestIndexesOf(t *testing.T) {
+	indexes, err := IndexesOf(reflect.TypeOf(&tagged{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Index{
+		{Name: "byTenantCreated", KeyPath: KeyPath{"tenant", "created"}},
+		{Name: "byEmail", KeyPath: KeyPath{"email"}, Unique: true},
+		{Name: "byTag", KeyPath: KeyPath{"tags"}, MultiEntry: true},
+		{Name: "byCreated", KeyPath: KeyPath{"created"}},
+		{Name: "byAuthor", KeyPath: KeyPath{"author.id"}},
+	}
+	if !reflect.DeepEqual(indexes, expected) {
+		t.Errorf("expected %v, got %v", expected, indexes)
+	}
+
+	// options given on a single field of a group apply to the whole group
+	indexes, err = IndexesOf(reflect.TypeOf(struct {
+		A string `json:"a" idb:"byAB"`
+		B string `json:"b" idb:"byAB,unique"`
+		C string `json:"c" idb:"byC,unique;byAB,unique"`
+	}{}))
+	if err != nil || !reflect.DeepEqual(indexes, []Index{
+		{Name: "byAB", KeyPath: KeyPath{"a", "b", "c"}, Unique: true},
+		{Name: "byC", KeyPath: KeyPath{"c"}, Unique: true},
+	}) {
+		t.Errorf("unexpected grouped options %v %v", indexes, err)
+	}
+
+	// the derived key paths resolve against the values they were derived from
+	v := tagged{tagBase: tagBase{"t"}, Author: &struct {
+		Id string `json:"id" idb:"byAuthor"`
+	}{"ann"}}
+	for _, path := range []string{"tenant", "author.id"} {
+		if _, ok := evaluateKeyPath(v, path); !ok {
+			t.Errorf("%s should resolve", path)
+		}
+	}
+
+	for _, in := range []interface{}{
+		"not a struct",
+		struct {
+			A string `idb:",unique"`
+		}{},
+		struct {
+			A string `idb:"byA,sparse"`
+		}{},
+		struct {
+			A string `idb:"byAB,unique"`
+			B string `idb:"byAB"`
+			C string `idb:"byAB,multi"`
+		}{},
+	} {
+		if _, err := IndexesOf(reflect.TypeOf(in)); err == nil {
+			t.Errorf("expected %T to fail", in)
+		}
+	}
+}
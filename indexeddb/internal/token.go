@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// cursorToken is the position of a cursor, exported so a later transaction can resume it
+type cursorToken struct {
+	Store     string    `json:"s"`
+	Index     string    `json:"i,omitempty"`
+	Direction Direction `json:"d"`
+	// Start and Limit are the encoded bounds of the cursor's range
+	Start []byte `json:"b,omitempty"`
+	Limit []byte `json:"l,omitempty"`
+	// Position is the encoded entry the cursor was on
+	Position []byte `json:"p"`
+}
+
+// newSecret generates the key that signs a database's cursor tokens
+func newSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	return secret, err
+}
+
+func (p *Database) tokenMAC(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.Secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// signToken encodes t followed by a signature, so tokens cannot be altered or forged
+func (p *Database) signToken(t cursorToken) (string, error) {
+	if len(p.Secret) == 0 {
+		return "", NewError(InvalidStateError, "database %s has no key to sign cursor tokens, it gets one with its next version upgrade", p.Name)
+	}
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(p.tokenMAC(payload)), nil
+}
+
+// parseToken checks the signature of a token made by signToken and decodes it
+func (p *Database) parseToken(token string) (cursorToken, error) {
+	var t cursorToken
+	invalid := NewError(DataError, "invalid cursor token")
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || len(p.Secret) == 0 {
+		return t, invalid
+	}
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return t, invalid
+	}
+	sig, err := enc.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, p.tokenMAC(payload)) {
+		return t, invalid
+	}
+	err = json.Unmarshal(payload, &t)
+	if err != nil || t.Position == nil {
+		return t, invalid
+	}
+	switch t.Direction {
+	case NEXT, PREV, NEXTUNIQUE, PREVUNIQUE:
+	default:
+		return t, invalid
+	}
+	return t, nil
+}
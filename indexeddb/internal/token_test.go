@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestTokenSecret(t *testing.T) {
+	stor := storage.NewMemStorage()
+	def, err := OpenStorage("test", stor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// opening does not write anything, the secret comes with the first migration
+	if len(def.Secret) != 0 {
+		t.Error("expected a new database to have no secret before its first migration")
+	}
+	if _, err := def.DB.Get(Key{}.forCore(), nil); !errors.Is(err, leveldb.ErrNotFound) {
+		t.Errorf("expected opening to leave the definition unwritten, got %v", err)
+	}
+	if _, err := def.signToken(cursorToken{}); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected signing without a secret to fail, got %v", err)
+	}
+
+	tr, err := def.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	def.Version = 1
+	if err := def.UpdateDefinition(tr); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Commit(&opt.WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	secret := def.Secret
+	if len(secret) == 0 {
+		t.Fatal("expected the migration to create a secret")
+	}
+	def.Close()
+
+	def, err = OpenStorage("test", stor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer def.Close()
+	if !bytes.Equal(def.Secret, secret) {
+		t.Error("expected the secret to be persisted")
+	}
+
+	// later migrations keep the secret
+	tr, err = def.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	def.Version = 2
+	if err := def.UpdateDefinition(tr); err != nil {
+		t.Fatal(err)
+	}
+	tr.Discard()
+	if !bytes.Equal(def.Secret, secret) {
+		t.Error("expected an existing secret to be kept")
+	}
+}
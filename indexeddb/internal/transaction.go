@@ -0,0 +1,721 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/memdb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// pending write markers, the first byte of every buffered value
+const (
+	opDelete byte = iota
+	opPut
+)
+
+// Transaction reads from a snapshot and buffers its writes in memory.
+// The writes reach the database in a single batch on commit, which lets the
+// caller choose whether the write is synced to disk.
+//
+// Once the buffer outgrows spillAt its writes move to a private LevelDB
+// database in a temporary directory, so large write sets do not stay in memory.
+// The database itself is only written on commit, so other transactions keep
+// committing meanwhile. A spilled commit copies the writes into a LevelDB
+// transaction, which holds the write lock of the database while it runs and,
+// like the large batches of LevelDB itself, is synced whatever the durability.
+type Transaction struct {
+	// mu serializes the operations with Discard, which may be called from
+	// another goroutine while a cursor steps
+	mu sync.Mutex
+
+	db       *leveldb.DB
+	snap     *leveldb.Snapshot
+	writes   *memdb.DB
+	readonly bool
+
+	// spilled holds the writes that outgrew the buffer, with their markers
+	spilled  *leveldb.DB
+	spillDir string
+	spillAt  int
+	// iters are the open iterators, marked stale when the buffer spills
+	iters map[*txIterator]struct{}
+	// version counts the writes, iterators seek their sources again after one
+	version int
+
+	// ctx cancels every operation of the transaction, call only the current one
+	ctx, call context.Context
+}
+
+// NewTransaction starts a transaction on a snapshot of the database
+func (p *Database) NewTransaction() (*Transaction, error) {
+	return p.newTransaction(false)
+}
+
+// NewReadonlyTransaction starts a transaction on a snapshot of the database
+// that rejects every write
+func (p *Database) NewReadonlyTransaction() (*Transaction, error) {
+	return p.newTransaction(true)
+}
+
+func (p *Database) newTransaction(readonly bool) (*Transaction, error) {
+	snap, err := p.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{
+		db:       p.DB,
+		snap:     snap,
+		writes:   memdb.New(comparer.DefaultComparer, 0),
+		readonly: readonly,
+		spillAt:  opt.DefaultWriteBuffer,
+		iters:    make(map[*txIterator]struct{}),
+	}, nil
+}
+
+// ReadOnly reports whether the transaction rejects writes
+func (p *Transaction) ReadOnly() bool {
+	return p.readonly
+}
+
+// SetContext makes every later operation of the transaction fail once ctx is done
+func (p *Transaction) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
+// CallContext makes the operations started until restore is called fail once
+// ctx is done. Iterators opened in between keep observing ctx.
+func (p *Transaction) CallContext(ctx context.Context) (restore func()) {
+	prev := p.call
+	p.call = ctx
+	return func() {
+		p.call = prev
+	}
+}
+
+// ContextErr returns the error of the first of ctxs that is done
+func ContextErr(ctxs ...context.Context) error {
+	for _, ctx := range ctxs {
+		if ctx == nil {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check reports whether the transaction may still be used
+func (p *Transaction) check(write bool) error {
+	if p.snap == nil {
+		return ErrTransactionInactive
+	}
+	if err := ContextErr(p.ctx, p.call); err != nil {
+		return err
+	}
+	if write && p.readonly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+func (p *Transaction) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(false); err != nil {
+		return nil, err
+	}
+	val, ok, err := p.pending(key)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if val[0] == opDelete {
+			return nil, leveldb.ErrNotFound
+		}
+		return append([]byte{}, val[1:]...), nil
+	}
+	return p.snap.Get(key, ro)
+}
+
+func (p *Transaction) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(false); err != nil {
+		return false, err
+	}
+	val, ok, err := p.pending(key)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return val[0] == opPut, nil
+	}
+	return p.snap.Has(key, ro)
+}
+
+// pending returns the write buffered for key, in memory or spilled
+func (p *Transaction) pending(key []byte) ([]byte, bool, error) {
+	val, err := p.writes.Get(key)
+	if err == nil {
+		return val, true, nil
+	}
+	if p.spilled == nil {
+		return nil, false, nil
+	}
+	val, err = p.spilled.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, false, nil
+	}
+	return val, err == nil, err
+}
+
+func (p *Transaction) Put(key, value []byte, wo *opt.WriteOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(true); err != nil {
+		return err
+	}
+	return p.write(opPut, key, value)
+}
+
+func (p *Transaction) Delete(key []byte, wo *opt.WriteOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(true); err != nil {
+		return err
+	}
+	return p.write(opDelete, key, nil)
+}
+
+// write buffers a single operation, spilling the buffer once it grows too large
+func (p *Transaction) write(op byte, key, value []byte) error {
+	p.version++
+	err := p.writes.Put(key, append([]byte{op}, value...))
+	if err != nil {
+		return err
+	}
+	if p.writes.Size() > p.spillAt {
+		return p.spill()
+	}
+	return nil
+}
+
+// spill moves the buffered writes to the private database, creating it first
+// when needed. Iterators reopen their sources on their next move.
+func (p *Transaction) spill() error {
+	if p.spilled == nil {
+		dir, err := os.MkdirTemp("", "indexeddb-spill-")
+		if err != nil {
+			return err
+		}
+		h, err := leveldb.OpenFile(dir, &opt.Options{NoSync: true})
+		if err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+		p.spilled, p.spillDir = h, dir
+	}
+
+	b := &leveldb.Batch{}
+	iter := p.writes.NewIterator(nil)
+	for iter.Next() {
+		b.Put(iter.Key(), iter.Value())
+	}
+	iter.Release()
+	err := p.spilled.Write(b, nil)
+	if err != nil {
+		return err
+	}
+	p.writes.Reset()
+	for iter := range p.iters {
+		iter.stale = true
+	}
+	return nil
+}
+
+// buffered collects the writes held in memory in a batch
+func (p *Transaction) buffered() *leveldb.Batch {
+	b := &leveldb.Batch{}
+	iter := p.writes.NewIterator(nil)
+	defer iter.Release()
+	for iter.Next() {
+		if iter.Value()[0] == opDelete {
+			b.Delete(iter.Key())
+		} else {
+			b.Put(iter.Key(), iter.Value()[1:])
+		}
+	}
+	return b
+}
+
+// Write buffers every operation of the batch
+func (p *Transaction) Write(b *leveldb.Batch, wo *opt.WriteOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(true); err != nil {
+		return err
+	}
+	r := &batchReplay{tr: p}
+	err := b.Replay(r)
+	if err != nil {
+		return err
+	}
+	return r.err
+}
+
+// NewIterator returns an iterator over the snapshot with the buffered writes applied
+func (p *Transaction) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(false); err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	iter := &txIterator{
+		tr:      p,
+		ctx:     p.ctx,
+		call:    p.call,
+		sources: p.sources(slice, ro),
+		slice:   slice,
+		ro:      ro,
+	}
+	p.iters[iter] = struct{}{}
+	return iter
+}
+
+// sources opens the iterators merged by txIterator, the most recent writes first
+// and the snapshot last
+func (p *Transaction) sources(slice *util.Range, ro *opt.ReadOptions) []iterator.Iterator {
+	out := []iterator.Iterator{p.writes.NewIterator(slice)}
+	if p.spilled != nil {
+		out = append(out, p.spilled.NewIterator(slice, nil))
+	}
+	return append(out, p.snap.NewIterator(slice, ro))
+}
+
+// Commit writes the buffered changes, syncing them to disk if wo asks for it
+func (p *Transaction) Commit(wo *opt.WriteOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(false); err != nil {
+		return err
+	}
+	if p.readonly {
+		p.discard()
+		return nil
+	}
+	if p.spilled != nil {
+		err := p.commitSpilled()
+		if err != nil {
+			return err
+		}
+		p.discard()
+		return nil
+	}
+
+	err := p.db.Write(p.buffered(), wo)
+	if err != nil {
+		return err
+	}
+	p.discard()
+	return nil
+}
+
+// commitSpilled copies every write into a LevelDB transaction and commits it.
+// The transaction writes tables, which are synced whatever the durability.
+func (p *Transaction) commitSpilled() error {
+	err := p.spill()
+	if err != nil {
+		return err
+	}
+	ltr, err := p.db.OpenTransaction()
+	if err != nil {
+		return err
+	}
+	iter := p.spilled.NewIterator(nil, nil)
+	for err == nil && iter.Next() {
+		if iter.Value()[0] == opDelete {
+			err = ltr.Delete(iter.Key(), nil)
+		} else {
+			err = ltr.Put(iter.Key(), iter.Value()[1:], nil)
+		}
+	}
+	if err == nil {
+		err = iter.Error()
+	}
+	iter.Release()
+	if err == nil {
+		err = ltr.Commit()
+	}
+	if err != nil {
+		ltr.Discard()
+		return err
+	}
+	return nil
+}
+
+// Discard drops the buffered changes and releases the snapshot. It may be
+// called from another goroutine, it waits for the running operation.
+func (p *Transaction) Discard() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.discard()
+}
+
+func (p *Transaction) discard() {
+	if p.snap == nil {
+		return
+	}
+	// the sources of open iterators go first, the private database closes with them
+	for iter := range p.iters {
+		iter.releaseSources()
+	}
+	p.snap.Release()
+	p.snap = nil
+	p.writes.Reset()
+	if p.spilled != nil {
+		p.spilled.Close()
+		os.RemoveAll(p.spillDir)
+		p.spilled, p.spillDir = nil, ""
+	}
+}
+
+type batchReplay struct {
+	tr  *Transaction
+	err error
+}
+
+func (p *batchReplay) Put(key, value []byte) {
+	if p.err == nil {
+		p.err = p.tr.write(opPut, key, value)
+	}
+}
+
+func (p *batchReplay) Delete(key []byte) {
+	if p.err == nil {
+		p.err = p.tr.write(opDelete, key, nil)
+	}
+}
+
+// iterator positions
+const (
+	atStart = iota
+	atKey
+	atEnd
+)
+
+// directions the sources of an iterator were last moved in
+const (
+	unplaced = iota
+	forwards
+	backwards
+)
+
+// txIterator merges the buffered writes over the snapshot.
+// Moves step the sources in place. After a write, a spill or a change of
+// direction they seek again from the current key instead, so writes made while
+// iterating do not invalidate it.
+type txIterator struct {
+	tr *Transaction
+	// ctx and call are the contexts of the transaction when the iterator was opened
+	ctx, call context.Context
+	// sources are ordered like Transaction.sources, every one but the snapshot
+	// holds values with their write marker
+	sources []iterator.Iterator
+
+	slice *util.Range
+	ro    *opt.ReadOptions
+	// stale is set when the buffer spilled and the sources miss its writes
+	stale bool
+	// dir and version are the direction and transaction version the sources
+	// were last moved at
+	dir, version int
+
+	pos        int
+	key, value []byte
+	err        error
+	released   bool
+	releaser   util.Releaser
+}
+
+// refresh reopens the sources of a stale iterator. The next move seeks them
+// from the current key, so the position carries over.
+func (p *txIterator) refresh() {
+	if !p.stale || p.tr.snap == nil {
+		return
+	}
+	p.releaseSources()
+	p.sources = p.tr.sources(p.slice, p.ro)
+	p.stale = false
+	p.dir = unplaced
+}
+
+// placed reports whether the sources can step on from the current key in dir
+func (p *txIterator) placed(dir int) bool {
+	return p.pos == atKey && p.dir == dir && p.version == p.tr.version
+}
+
+// stepSources moves the sources positioned on key one entry on
+func (p *txIterator) stepSources(key []byte, step func(iterator.Iterator) bool) {
+	for _, iter := range p.sources {
+		if iter.Valid() && bytes.Equal(iter.Key(), key) {
+			step(iter)
+		}
+	}
+}
+
+// releaseSources releases the iterators merged by the iterator
+func (p *txIterator) releaseSources() {
+	for _, iter := range p.sources {
+		iter.Release()
+	}
+	p.sources = nil
+}
+
+// forward moves to the first visible key after from, or at from when inclusive
+func (p *txIterator) forward(from []byte, inclusive bool) bool {
+	p.refresh()
+	if !inclusive && p.placed(forwards) && bytes.Equal(from, p.key) {
+		p.stepSources(from, iterator.Iterator.Next)
+	} else {
+		for _, iter := range p.sources {
+			seekForward(iter, from, inclusive)
+		}
+	}
+	p.dir, p.version = forwards, p.tr.version
+	for {
+		found := -1
+		for i, iter := range p.sources {
+			if iter.Valid() && (found < 0 || bytes.Compare(iter.Key(), p.sources[found].Key()) < 0) {
+				found = i
+			}
+		}
+		if !p.check() {
+			return false
+		}
+		if found < 0 {
+			return p.end(atEnd)
+		}
+		if p.visit(found) {
+			return true
+		}
+		p.stepSources(append([]byte{}, p.sources[found].Key()...), iterator.Iterator.Next)
+	}
+}
+
+// backward moves to the last visible key before from, or at from when inclusive
+func (p *txIterator) backward(from []byte, inclusive bool) bool {
+	p.refresh()
+	if !inclusive && p.placed(backwards) && bytes.Equal(from, p.key) {
+		p.stepSources(from, iterator.Iterator.Prev)
+	} else {
+		for _, iter := range p.sources {
+			seekBackward(iter, from, inclusive)
+		}
+	}
+	p.dir, p.version = backwards, p.tr.version
+	for {
+		found := -1
+		for i, iter := range p.sources {
+			if iter.Valid() && (found < 0 || bytes.Compare(iter.Key(), p.sources[found].Key()) > 0) {
+				found = i
+			}
+		}
+		if !p.check() {
+			return false
+		}
+		if found < 0 {
+			return p.end(atStart)
+		}
+		if p.visit(found) {
+			return true
+		}
+		p.stepSources(append([]byte{}, p.sources[found].Key()...), iterator.Iterator.Prev)
+	}
+}
+
+// visit moves to the entry of source i, reporting false for a deleted key
+func (p *txIterator) visit(i int) bool {
+	iter := p.sources[i]
+	if i == len(p.sources)-1 {
+		return p.set(iter.Key(), iter.Value())
+	}
+	if iter.Value()[0] == opDelete {
+		return false
+	}
+	return p.set(iter.Key(), iter.Value()[1:])
+}
+
+func seekForward(iter iterator.Iterator, from []byte, inclusive bool) bool {
+	if from == nil {
+		return iter.First()
+	}
+	if !iter.Seek(from) {
+		return false
+	}
+	if !inclusive && bytes.Equal(iter.Key(), from) {
+		return iter.Next()
+	}
+	return true
+}
+
+func seekBackward(iter iterator.Iterator, from []byte, inclusive bool) bool {
+	if from == nil {
+		return iter.Last()
+	}
+	if !iter.Seek(from) {
+		return iter.Last()
+	}
+	if inclusive && bytes.Equal(iter.Key(), from) {
+		return true
+	}
+	return iter.Prev()
+}
+
+func (p *txIterator) check() bool {
+	if p.tr.snap == nil {
+		p.err = ErrTransactionInactive
+	} else if err := ContextErr(p.ctx, p.call); err != nil {
+		p.err = err
+	} else {
+		for _, iter := range p.sources {
+			if err := iter.Error(); err != nil {
+				p.err = err
+				break
+			}
+		}
+	}
+	if p.err != nil {
+		p.end(atEnd)
+		return false
+	}
+	return true
+}
+
+func (p *txIterator) set(key, value []byte) bool {
+	p.pos = atKey
+	p.key = append([]byte{}, key...)
+	p.value = append([]byte{}, value...)
+	return true
+}
+
+func (p *txIterator) end(pos int) bool {
+	p.pos = pos
+	p.key, p.value = nil, nil
+	return false
+}
+
+func (p *txIterator) First() bool {
+	p.tr.mu.Lock()
+	defer p.tr.mu.Unlock()
+	return p.first()
+}
+
+func (p *txIterator) first() bool {
+	if p.err != nil {
+		return false
+	}
+	return p.forward(nil, true)
+}
+
+func (p *txIterator) Last() bool {
+	p.tr.mu.Lock()
+	defer p.tr.mu.Unlock()
+	return p.last()
+}
+
+func (p *txIterator) last() bool {
+	if p.err != nil {
+		return false
+	}
+	return p.backward(nil, true)
+}
+
+func (p *txIterator) Seek(key []byte) bool {
+	p.tr.mu.Lock()
+	defer p.tr.mu.Unlock()
+	if p.err != nil {
+		return false
+	}
+	return p.forward(append([]byte{}, key...), true)
+}
+
+func (p *txIterator) Next() bool {
+	p.tr.mu.Lock()
+	defer p.tr.mu.Unlock()
+	if p.err != nil {
+		return false
+	}
+	switch p.pos {
+	case atStart:
+		return p.first()
+	case atEnd:
+		return false
+	}
+	return p.forward(p.key, false)
+}
+
+func (p *txIterator) Prev() bool {
+	p.tr.mu.Lock()
+	defer p.tr.mu.Unlock()
+	if p.err != nil {
+		return false
+	}
+	switch p.pos {
+	case atStart:
+		return false
+	case atEnd:
+		return p.last()
+	}
+	return p.backward(p.key, false)
+}
+
+func (p *txIterator) Valid() bool {
+	return p.pos == atKey
+}
+
+func (p *txIterator) Key() []byte {
+	return p.key
+}
+
+func (p *txIterator) Value() []byte {
+	return p.value
+}
+
+func (p *txIterator) Error() error {
+	return p.err
+}
+
+func (p *txIterator) Release() {
+	p.tr.mu.Lock()
+	defer p.tr.mu.Unlock()
+	if p.released {
+		return
+	}
+	p.released = true
+	p.releaseSources()
+	delete(p.tr.iters, p)
+	p.end(atEnd)
+	p.err = iterator.ErrIterReleased
+	if p.releaser != nil {
+		p.releaser.Release()
+		p.releaser = nil
+	}
+}
+
+func (p *txIterator) SetReleaser(releaser util.Releaser) {
+	if p.released {
+		panic(util.ErrReleased)
+	}
+	if p.releaser != nil && releaser != nil {
+		panic(util.ErrHasReleaser)
+	}
+	p.releaser = releaser
+}
@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func openMemDatabase(t *testing.T) *Database {
+	t.Helper()
+	def, err := OpenStorage("test", storage.NewMemStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { def.Close() })
+	return def
+}
+
+func collect(iter iterator.Iterator, next func() bool) []string {
+	out := []string{}
+	for next() {
+		out = append(out, string(iter.Key())+"="+string(iter.Value()))
+	}
+	return out
+}
+
+func TestTransactionOverlay(t *testing.T) {
+	def := openMemDatabase(t)
+	for _, k := range []string{"a", "c", "e"} {
+		if err := def.Put([]byte(k), []byte(k), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tr, err := def.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Discard()
+
+	b := &leveldb.Batch{}
+	b.Put([]byte("b"), []byte("b"))
+	b.Delete([]byte("c"))
+	b.Put([]byte("e"), []byte("e2"))
+	if err := tr.Write(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Put([]byte("f"), []byte("f"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	iter := tr.NewIterator(nil, nil)
+	defer iter.Release()
+
+	forward := []string{"a=a", "b=b", "e=e2", "f=f"}
+	if got := collect(iter, iter.Next); !reflect.DeepEqual(got, forward) {
+		t.Errorf("forward: expected %v, got %v", forward, got)
+	}
+	backward := []string{"f=f", "e=e2", "b=b", "a=a"}
+	if got := collect(iter, iter.Prev); !reflect.DeepEqual(got, backward) {
+		t.Errorf("backward: expected %v, got %v", backward, got)
+	}
+	if !iter.Seek([]byte("c")) || string(iter.Key()) != "e" {
+		t.Errorf("seek should skip the deleted key, got %q", iter.Key())
+	}
+	if !iter.Prev() || string(iter.Key()) != "b" {
+		t.Errorf("prev should skip the deleted key, got %q", iter.Key())
+	}
+
+	// writes made while iterating are picked up by the next move
+	if err := tr.Delete([]byte("e"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !iter.Next() || string(iter.Key()) != "f" {
+		t.Errorf("next should skip the key deleted while iterating, got %q", iter.Key())
+	}
+	if err := tr.Put([]byte("fa"), []byte("fa"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !iter.Prev() || string(iter.Key()) != "b" || !iter.Next() || string(iter.Key()) != "f" {
+		t.Errorf("changing direction should keep the position, got %q", iter.Key())
+	}
+	if !iter.Next() || string(iter.Key()) != "fa" || iter.Next() {
+		t.Errorf("next should find the key written ahead of the iterator, got %q", iter.Key())
+	}
+
+	bounded := tr.NewIterator(&util.Range{Start: []byte("b"), Limit: []byte("f")}, nil)
+	defer bounded.Release()
+	if got := collect(bounded, bounded.Next); !reflect.DeepEqual(got, []string{"b=b"}) {
+		t.Errorf("range: expected [b=b], got %v", got)
+	}
+
+	if _, err := tr.Get([]byte("c"), nil); err != leveldb.ErrNotFound {
+		t.Errorf("deleted key should not be found, got %v", err)
+	}
+	if ok, _ := tr.Has([]byte("b"), nil); !ok {
+		t.Error("buffered key should exist")
+	}
+	if ok, _ := def.Has([]byte("b"), nil); ok {
+		t.Error("buffered key should not reach the database before commit")
+	}
+
+	if err := tr.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	stored := def.NewIterator(nil, nil)
+	defer stored.Release()
+	committed := []string{"a=a", "b=b", "f=f", "fa=fa"}
+	if got := collect(stored, stored.Next); !reflect.DeepEqual(got, committed) {
+		t.Errorf("committed: expected %v, got %v", committed, got)
+	}
+	if err := tr.Put([]byte("g"), nil, nil); !errors.Is(err, ErrTransactionInactive) {
+		t.Errorf("expected writes after commit to fail, got %v", err)
+	}
+}
+
+func TestTransactionSpill(t *testing.T) {
+	def := openMemDatabase(t)
+	for _, k := range []string{"a", "c"} {
+		if err := def.Put([]byte(k), []byte(k), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tr, err := def.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.spillAt = 4
+
+	iter := tr.NewIterator(nil, nil)
+	defer iter.Release()
+	if !iter.Next() || string(iter.Key()) != "a" {
+		t.Fatalf("expected a, got %q", iter.Key())
+	}
+	if err := tr.Put([]byte("b"), []byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Delete([]byte("c"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if tr.spilled == nil {
+		t.Fatal("expected the writes to spill")
+	}
+	if err := tr.Put([]byte("d"), []byte("d"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// iterators opened before the spill see every write
+	if got := collect(iter, iter.Next); !reflect.DeepEqual(got, []string{"b=b", "d=d"}) {
+		t.Errorf("expected [b=b d=d] after a, got %v", got)
+	}
+	if _, err := tr.Get([]byte("c"), nil); err != leveldb.ErrNotFound {
+		t.Errorf("deleted key should not be found, got %v", err)
+	}
+	if ok, _ := def.Has([]byte("b"), nil); ok {
+		t.Error("spilled writes should not be visible before commit")
+	}
+
+	// the spill leaves the database alone, other transactions commit meanwhile
+	other, err := def.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Put([]byte("z"), []byte("z"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Get([]byte("z"), nil); err != leveldb.ErrNotFound {
+		t.Errorf("writes committed after the snapshot should not be visible, got %v", err)
+	}
+
+	dir := tr.spillDir
+	if err := tr.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected the spill directory to be removed, got %v", err)
+	}
+	stored := def.NewIterator(nil, nil)
+	defer stored.Release()
+	if got := collect(stored, stored.Next); !reflect.DeepEqual(got, []string{"a=a", "b=b", "d=d", "z=z"}) {
+		t.Errorf("committed: expected [a=a b=b d=d z=z], got %v", got)
+	}
+
+	// a discarded spill writes nothing and frees the database for other writes
+	tr, err = def.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.spillAt = 0
+	if err := tr.Put([]byte("e"), []byte("e"), nil); err != nil {
+		t.Fatal(err)
+	}
+	iter = tr.NewIterator(nil, nil)
+	defer iter.Release()
+	dir = tr.spillDir
+	tr.Discard()
+	if iter.Next() || !errors.Is(iter.Error(), ErrTransactionInactive) {
+		t.Errorf("expected iterators to stop once discarded, got %v", iter.Error())
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected the spill directory to be removed, got %v", err)
+	}
+	if ok, _ := def.Has([]byte("e"), nil); ok {
+		t.Error("discarded writes should not reach the database")
+	}
+	if err := def.Put([]byte("f"), []byte("f"), nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -6,6 +6,9 @@ import (
 
 type Key = internal.Key
 
+// KeyPath locates the key of a record, see StoreOptions
+type KeyPath = internal.KeyPath
+
 type Range = internal.Range
 
 // Lowerbound generates a range starting at the selected key
@@ -40,11 +43,7 @@ func All() Range {
 
 // Only generates a range with an exact match
 func Only(key internal.Key) Range {
-	next := key.Next()
-	return Range{
-		Start: &key,
-		Limit: &next,
-	}
+	return internal.Only(key)
 }
 
 // Prefix generates a range satisfying the Key as a prefix
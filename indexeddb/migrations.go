@@ -1,7 +1,7 @@
 package indexeddb
 
 import (
-	"fmt"
+	"reflect"
 
 	"github.com/huffduff/go-indexeddb/indexeddb/internal"
 )
@@ -11,6 +11,8 @@ type Migration interface{}
 type MigrationTransaction struct {
 	def *internal.Database
 	tr  *Transaction
+	// codec is used by stores created without one
+	codec Codec
 }
 
 func (p *MigrationTransaction) CreateStore(name string, opts StoreOptions) (*MigrationTransactionStore, error) {
@@ -19,6 +21,17 @@ func (p *MigrationTransaction) CreateStore(name string, opts StoreOptions) (*Mig
 		KeyPath:       opts.KeyPath,
 		AutoIncrement: opts.AutoIncrement,
 	}
+	codec := opts.Codec
+	if codec == nil {
+		codec = p.codec
+	}
+	if codec != nil {
+		err := RegisterCodec(codec)
+		if err != nil {
+			return nil, err
+		}
+		spec.Codec = codec.Name()
+	}
 	h, err := p.def.CreateStore(p.tr.h, spec)
 	if err != nil {
 		return nil, err
@@ -28,6 +41,34 @@ func (p *MigrationTransaction) CreateStore(name string, opts StoreOptions) (*Mig
 	return &MigrationTransactionStore{store}, nil
 }
 
+// Store returns an existing store for schema changes
+func (p *MigrationTransaction) Store(name string) (*MigrationTransactionStore, error) {
+	h, ok := p.def.Stores[name]
+	if !ok {
+		return nil, internal.NewError(NotFoundError, "store %s does not exist", name)
+	}
+	store := TransactionStore{BaseStore{h}, p.tr}
+	return &MigrationTransactionStore{store}, nil
+}
+
+// DeleteStore removes a store along with its records and indexes
+func (p *MigrationTransaction) DeleteStore(name string) error {
+	h, ok := p.def.Stores[name]
+	if !ok {
+		return internal.NewError(NotFoundError, "store %s does not exist", name)
+	}
+	return p.def.DeleteStore(p.tr.h, h)
+}
+
+// RenameStore moves a store along with its records and indexes to a new name
+func (p *MigrationTransaction) RenameStore(name string, newName string) error {
+	h, ok := p.def.Stores[name]
+	if !ok {
+		return internal.NewError(NotFoundError, "store %s does not exist", name)
+	}
+	return p.def.RenameStore(p.tr.h, h, newName)
+}
+
 type MigrationTransactionStore struct {
 	TransactionStore
 	// tr MigrationTransaction
@@ -45,72 +86,192 @@ func (p *MigrationTransactionStore) CreateIndex(name string, opts IndexOptions)
 	return err
 }
 
+// CreateIndexesFor creates the indexes declared by the idb struct tags of v, see IndexesOf.
+// Indexes that already exist with the same definition are left as they are.
+func (p *MigrationTransactionStore) CreateIndexesFor(v interface{}) error {
+	specs, err := IndexesOf(v)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if idx, ok := p.def.Indexes[spec.Name]; ok {
+			if !reflect.DeepEqual(idx.KeyPath, spec.Options.KeyPath) || idx.Unique != spec.Options.Unique || idx.MultiEntry != spec.Options.MultiEntry {
+				return internal.NewError(ConstraintError, "index %s already exists with a different definition", spec.Name)
+			}
+			continue
+		}
+		err = p.CreateIndex(spec.Name, spec.Options)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *MigrationTransactionStore) DeleteIndex(name string) error {
-	// TODO we shouldn't need an instance, just the prefix
 	idx, ok := p.def.Indexes[name]
 	if !ok {
-		return fmt.Errorf("index %s does not exist", name)
+		return internal.NewError(NotFoundError, "index %s does not exist", name)
+	}
+	return p.def.Database.DeleteIndex(p.Transaction.h, idx)
+}
+
+// RenameIndex moves an index and its entries to a new name
+func (p *MigrationTransactionStore) RenameIndex(name string, newName string) error {
+	idx, ok := p.def.Indexes[name]
+	if !ok {
+		return internal.NewError(NotFoundError, "index %s does not exist", name)
 	}
-	return p.def.DeleteIndex(p.Transaction.h, idx)
+	return p.def.Database.RenameIndex(p.Transaction.h, idx, newName)
 }
 
 type migrator struct {
-	Migrate func(f func(version uint, h *MigrationTransaction) error) (*Database, error)
+	factory    *Factory
+	name       string
+	version    uint
+	err        error
+	blocked    func(oldVersion, newVersion uint)
+	durability TransactionDurability
+	codec      Codec
 }
 
 // migrateError ignores the callback and immediately return the error
 func migrateError(err error) *migrator {
-	return &migrator{
-		func(_ func(_ uint, _ *MigrationTransaction) error) (*Database, error) {
-			return nil, err
-		},
-	}
+	return &migrator{err: err}
 }
 
-// migrateDone ignores the callback and immediately return the db
-func migrateDone(current *internal.Database) *migrator {
-	return &migrator{
-		func(_ func(_ uint, _ *MigrationTransaction) error) (*Database, error) {
-			err := current.Hydrate()
-			if err != nil {
-				return nil, err
-			}
-			return &Database{current}, nil
-		},
+// OnBlocked registers a callback fired when an upgrade has to wait for
+// other connections to the database to close.
+func (p *migrator) OnBlocked(cb func(oldVersion, newVersion uint)) *migrator {
+	p.blocked = cb
+	return p
+}
+
+// Durability sets the durability used by transactions asking for Default,
+// including the migration itself. Without it Default behaves like Strict.
+func (p *migrator) Durability(d TransactionDurability) *migrator {
+	p.durability = d
+	return p
+}
+
+// Codec sets the codec of the stores created by the migration without one.
+// Existing stores keep the codec they were created with.
+func (p *migrator) Codec(c Codec) *migrator {
+	p.codec = c
+	return p
+}
+
+// Migrate returns a handle for the database, running the callback first if the
+// existing version is lower than the requested version.
+// Other open connections receive a versionchange notification and the upgrade
+// waits until they have all been closed.
+func (p *migrator) Migrate(callback func(version uint, h *MigrationTransaction) error) (*Database, error) {
+	if p.err != nil {
+		return nil, p.err
 	}
+	f := p.factory
+
+	sync, err := Default.synced(p.durability)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := f.connect(p.name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	// only one upgrade may run at a time, later opens queue behind it
+	for conn.upgrading {
+		f.cond.Wait()
+	}
+	current := conn.def.Version
+
+	if current > p.version {
+		f.mu.Unlock()
+		f.release(conn)
+		return nil, internal.NewError(VersionError, "existing database version %d > %d", current, p.version)
+	}
+
+	if current == p.version {
+		db := conn.attach(f)
+		db.durability = p.durability
+		f.mu.Unlock()
+		return db, nil
+	}
+
+	conn.upgrading = true
+	notify := conn.versionChangeCallbacks()
+	f.mu.Unlock()
+
+	for _, cb := range notify {
+		cb(current, p.version)
+	}
+
+	f.mu.Lock()
+	if len(conn.handles) > 0 && p.blocked != nil {
+		f.mu.Unlock()
+		p.blocked(current, p.version)
+		f.mu.Lock()
+	}
+	for len(conn.handles) > 0 {
+		f.cond.Wait()
+	}
+	f.mu.Unlock()
+
+	err = migrate(conn.def, p.version, sync, p.codec, callback)
+
+	f.mu.Lock()
+	conn.upgrading = false
+	f.cond.Broadcast()
+	var db *Database
+	if err == nil {
+		db = conn.attach(f)
+		db.durability = p.durability
+	}
+	f.mu.Unlock()
+
+	if err != nil {
+		f.release(conn)
+		return nil, err
+	}
+	return db, nil
 }
 
-// migrateRun returns an updated handle for the database if all migrations succeed
-func migrateRun(current *internal.Database, to uint) *migrator {
-	return &migrator{
-		func(callback func(v uint, h *MigrationTransaction) error) (*Database, error) {
-			t, err := newTransaction(current, current.StoreNames(), Default)
-			if err != nil {
-				return nil, err
-			}
+// migrate runs the callback in a single transaction and updates the stored version.
+// On failure the in memory definition is reloaded from storage.
+func migrate(current *internal.Database, to uint, sync bool, codec Codec, callback func(v uint, h *MigrationTransaction) error) error {
+	from, secret := current.Version, current.Secret
 
-			err = callback(current.Version, &MigrationTransaction{current, t})
-			if err != nil {
-				t.h.Discard()
-				return nil, fmt.Errorf("migration discarded %w", err)
-			}
+	t, err := newTransaction(current, current.StoreNames(), Default)
+	if err != nil {
+		return err
+	}
+	t.sync = sync
 
-			current.Version = to
+	fail := func(err error) error {
+		t.Abort()
+		current.Version, current.Secret = from, secret
+		if herr := current.Hydrate(); herr != nil {
+			return herr
+		}
+		return err
+	}
 
-			err = current.UpdateDefinition(t.h)
-			if err == nil {
-				err = t.Commit()
-			}
-			if err != nil {
-				t.h.Discard()
-				return nil, fmt.Errorf("migration commit failed %w", err)
-			}
+	err = callback(from, &MigrationTransaction{current, t, codec})
+	if err != nil {
+		return fail(internal.NewError(AbortError, "migration discarded: %w", err))
+	}
 
-			err = current.Hydrate()
-			if err != nil {
-				return nil, err
-			}
-			return &Database{current}, nil
-		},
+	current.Version = to
+
+	err = current.UpdateDefinition(t.h)
+	if err == nil {
+		err = t.Commit()
+	}
+	if err != nil {
+		return fail(internal.NewError(AbortError, "migration commit failed: %w", err))
 	}
+
+	return current.Hydrate()
 }
@@ -0,0 +1,456 @@
+package indexeddb
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDeleteStore(t *testing.T) {
+	f := NewFactory(t.TempDir())
+
+	db, err := f.Open("db", 1).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.CreateStore("events", StoreOptions{AutoIncrement: true})
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := s.Put(map[string]interface{}{"type": "click"}); err != nil {
+				return err
+			}
+		}
+		if err := s.CreateIndex("byType", IndexOptions{KeyPath: KeyPath{"type"}}); err != nil {
+			return err
+		}
+		kept, err := h.CreateStore("kept", StoreOptions{})
+		if err != nil {
+			return err
+		}
+		return kept.PutWithKey(Key{"a"}, "kept")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = f.Open("db", 2).Migrate(func(_ uint, h *MigrationTransaction) error {
+		if err := h.DeleteStore("events"); err != nil {
+			return err
+		}
+		_, err := h.CreateStore("events", StoreOptions{AutoIncrement: true})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rt, err := db.Transaction([]string{"events", "kept"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Abort()
+
+	if n, err := must(rt.Store("events")).Count(All()); err != nil || n != 0 {
+		t.Errorf("expected the recreated store to be empty, got %d %v", n, err)
+	}
+	if names := must(rt.Store("events")).IndexNames(); len(names) != 0 {
+		t.Errorf("expected indexes to be deleted, got %v", names)
+	}
+	if key, err := must(rt.Store("events")).Put("event"); err != nil || !reflect.DeepEqual(key, Key{1.0}) {
+		t.Errorf("expected the key generator to restart, got %v %v", key, err)
+	}
+	var kept string
+	if err := must(rt.Store("kept")).GetExact(Key{"a"}, &kept); err != nil || kept != "kept" {
+		t.Errorf("other stores should be untouched, got %q %v", kept, err)
+	}
+}
+
+func TestRenameStore(t *testing.T) {
+	f := NewFactory(t.TempDir())
+
+	db, err := f.Open("db", 1).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.CreateStore("old", StoreOptions{AutoIncrement: true})
+		if err != nil {
+			return err
+		}
+		if _, err := s.Put(map[string]interface{}{"type": "click"}); err != nil {
+			return err
+		}
+		tagged, err := h.CreateStore("tagged", StoreOptions{})
+		if err != nil {
+			return err
+		}
+		return tagged.CreateIndex("byType", IndexOptions{KeyPath: KeyPath{"type"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = f.Open("db", 2).Migrate(func(_ uint, h *MigrationTransaction) error {
+		if err := h.RenameStore("missing", "other"); err == nil {
+			t.Error("expected renaming a missing store to fail")
+		}
+		if err := h.RenameStore("old", "tagged"); err == nil {
+			t.Error("expected renaming onto an existing store to fail")
+		}
+		if err := h.RenameStore("old", "new"); err != nil {
+			return err
+		}
+		s, err := h.Store("tagged")
+		if err != nil {
+			return err
+		}
+		return s.RenameIndex("byType", "byKind")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	names := db.StoreNames()
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"new", "tagged"}) {
+		t.Errorf("unexpected stores %v", names)
+	}
+
+	tr, err := db.Transaction([]string{"new", "tagged"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+
+	if names := must(tr.Store("tagged")).IndexNames(); !reflect.DeepEqual(names, []string{"byKind"}) {
+		t.Errorf("unexpected indexes %v", names)
+	}
+
+	s := must(tr.Store("new"))
+	var out map[string]interface{}
+	if err := s.GetExact(Key{1.0}, &out); err != nil || out["type"] != "click" {
+		t.Errorf("expected records to move with the store, got %v %v", out, err)
+	}
+	if key, err := s.Put("view"); err != nil || !reflect.DeepEqual(key, Key{2.0}) {
+		t.Errorf("expected the key generator to move with the store, got %v %v", key, err)
+	}
+}
+
+func TestDeleteIndex(t *testing.T) {
+	f := NewFactory(t.TempDir())
+
+	db, err := f.Open("db", 1).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.CreateStore("users", StoreOptions{})
+		if err != nil {
+			return err
+		}
+		if err := s.CreateIndex("byEmail", IndexOptions{KeyPath: KeyPath{"email"}, Unique: true}); err != nil {
+			return err
+		}
+		if err := s.CreateIndex("byName", IndexOptions{KeyPath: KeyPath{"name"}, Unique: true}); err != nil {
+			return err
+		}
+		if err := s.PutWithKey(Key{1.0}, map[string]interface{}{"email": "a", "name": "ann"}); err != nil {
+			return err
+		}
+		return s.PutWithKey(Key{2.0}, map[string]interface{}{"email": "b"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// the names of deleted indexes are free for other indexes
+	db, err = f.Open("db", 2).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.Store("users")
+		if err != nil {
+			return err
+		}
+		if err := s.DeleteIndex("missing"); err == nil {
+			t.Error("expected deleting a missing index to fail")
+		}
+		if err := s.DeleteIndex("byEmail"); err != nil {
+			return err
+		}
+		if err := s.RenameIndex("byName", "byEmail"); err != nil {
+			return err
+		}
+		if err := s.DeleteIndex("byEmail"); err != nil {
+			return err
+		}
+		return s.CreateIndex("byEmail", IndexOptions{KeyPath: KeyPath{"name"}, Unique: true})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tr, err := db.Transaction([]string{"users"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+	s := must(tr.Store("users"))
+	if err := s.PutWithKey(Key{3.0}, map[string]interface{}{"name": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	// record 2 had an entry for "b" in the first byEmail index, deleting it must
+	// leave the entry of record 3 alone
+	if err := s.DeleteExact(Key{2.0}); err != nil {
+		t.Fatal(err)
+	}
+	idx := must(s.Index("byEmail"))
+	for name, expected := range map[string]Key{"ann": {1.0}, "b": {3.0}} {
+		if key, err := idx.GetKey(Only(Key{name})); err != nil || !reflect.DeepEqual(key, expected) {
+			t.Errorf("%s: expected the entry of %v, got %v %v", name, expected, key, err)
+		}
+	}
+	if n, err := idx.Count(All()); err != nil || n != 2 {
+		t.Errorf("expected 2 entries, got %d %v", n, err)
+	}
+}
+
+func TestIndexNamesPerStore(t *testing.T) {
+	f := NewFactory(t.TempDir())
+
+	db, err := f.Open("db", 1).Migrate(func(_ uint, h *MigrationTransaction) error {
+		for name, kind := range map[string]string{"a": "click", "b": "view"} {
+			s, err := h.CreateStore(name, StoreOptions{})
+			if err != nil {
+				return err
+			}
+			if err := s.CreateIndex("byType", IndexOptions{KeyPath: KeyPath{"type"}}); err != nil {
+				return err
+			}
+			if err := s.PutWithKey(Key{1.0}, map[string]interface{}{"type": kind}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = f.Open("db", 2).Migrate(func(_ uint, h *MigrationTransaction) error {
+		if err := h.RenameStore("a", "c"); err != nil {
+			return err
+		}
+		s, err := h.Store("b")
+		if err != nil {
+			return err
+		}
+		if err := s.RenameIndex("byType", "byKind"); err != nil {
+			return err
+		}
+		return s.CreateIndex("byType", IndexOptions{KeyPath: KeyPath{"type"}, Unique: true})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rt, err := db.ReadonlyTransaction([]string{"b", "c"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+	for _, c := range []struct {
+		store, index, kind string
+	}{{"c", "byType", "click"}, {"b", "byKind", "view"}, {"b", "byType", "view"}} {
+		idx := must(must(rt.Store(c.store)).Index(c.index))
+		if n, err := idx.Count(All()); err != nil || n != 1 {
+			t.Errorf("%s.%s: expected a single entry, got %d %v", c.store, c.index, n, err)
+		}
+		var out map[string]interface{}
+		if err := idx.Get(Only(Key{c.kind}), &out); err != nil || out["type"] != c.kind {
+			t.Errorf("%s.%s: expected the %s record, got %v %v", c.store, c.index, c.kind, out, err)
+		}
+	}
+}
+
+func TestCreateIndexBackfill(t *testing.T) {
+	f := NewFactory(t.TempDir())
+
+	type user struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	db, err := f.Open("db", 1).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.CreateStore("users", StoreOptions{KeyPath: KeyPath{"email"}})
+		if err != nil {
+			return err
+		}
+		for _, u := range []user{{"a@x", "admin"}, {"b@x", "user"}, {"c@x", "user"}} {
+			if _, err := s.Put(u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = f.Open("db", 2).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.Store("users")
+		if err != nil {
+			return err
+		}
+		if err := s.CreateIndex("byRole", IndexOptions{KeyPath: KeyPath{"role"}}); err != nil {
+			return err
+		}
+		if err := s.CreateIndex("byEmail", IndexOptions{KeyPath: KeyPath{"email"}, Unique: true}); err != nil {
+			return err
+		}
+		// the new index is maintained by writes later in the same migration
+		_, err = s.Put(user{"d@x", "admin"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := db.Transaction([]string{"users"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := must(tr.Store("users"))
+	for role, expected := range map[string]uint{"admin": 2, "user": 2} {
+		if n, err := must(s.Index("byRole")).Count(Only(Key{role})); err != nil || n != expected {
+			t.Errorf("expected %d %s entries, got %d %v", expected, role, n, err)
+		}
+	}
+	if key, err := must(s.Index("byEmail")).GetKey(Only(Key{"c@x"})); err != nil || !reflect.DeepEqual(key, Key{"c@x"}) {
+		t.Errorf("expected the unique index to point at c@x, got %v %v", key, err)
+	}
+
+	// writes keep the backfilled entries up to date
+	if _, err := s.Put(user{"b@x", "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteExact(Key{"c@x"}); err != nil {
+		t.Fatal(err)
+	}
+	for role, expected := range map[string]uint{"admin": 3, "user": 0} {
+		if n, err := must(s.Index("byRole")).Count(Only(Key{role})); err != nil || n != expected {
+			t.Errorf("expected %d %s entries after writes, got %d %v", expected, role, n, err)
+		}
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	_, err = f.Open("db", 3).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.Store("users")
+		if err != nil {
+			return err
+		}
+		return s.CreateIndex("uniqueRole", IndexOptions{KeyPath: KeyPath{"role"}, Unique: true})
+	})
+	if err == nil {
+		t.Fatal("expected duplicate keys in a unique index to abort the migration")
+	}
+
+	db, err = f.Open("db", 2).Migrate(noMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	names := db.conn.def.Stores["users"].IndexNames()
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"byEmail", "byRole"}) {
+		t.Errorf("the aborted index should not exist, got %v", names)
+	}
+}
+
+func TestCreateIndexesFor(t *testing.T) {
+	f := NewFactory(t.TempDir())
+
+	type comment struct {
+		Id        string   `json:"_id"`
+		ArticleId string   `json:"articleId" idb:"byArticle"`
+		Author    string   `json:"author" idb:"byAuthor"`
+		Tags      []string `json:"tags" idb:"byTag,multi"`
+	}
+	type reply struct {
+		Id     string `json:"_id"`
+		Author string `json:"author" idb:"byAuthor,unique"`
+	}
+
+	db, err := f.Open("db", 1).Migrate(func(_ uint, h *MigrationTransaction) error {
+		// index names are scoped by store, another type may declare the same ones
+		replies, err := h.CreateStore("replies", StoreOptions{KeyPath: KeyPath{"_id"}})
+		if err != nil {
+			return err
+		}
+		if err := replies.CreateIndexesFor(reply{}); err != nil {
+			return err
+		}
+		s, err := h.CreateStore("comments", StoreOptions{KeyPath: KeyPath{"_id"}})
+		if err != nil {
+			return err
+		}
+		if _, err := s.Put(comment{"1", "a", "ann", []string{"x", "y"}}); err != nil {
+			return err
+		}
+		if err := s.CreateIndexesFor(comment{}); err != nil {
+			return err
+		}
+		// indexes that already exist are kept
+		if err := s.CreateIndexesFor(&comment{}); err != nil {
+			return err
+		}
+		return s.CreateIndex("byBody", IndexOptions{KeyPath: KeyPath{"body"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := db.Transaction([]string{"comments"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+	s := must(tr.Store("comments"))
+
+	names := s.IndexNames()
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"byArticle", "byAuthor", "byBody", "byTag"}) {
+		t.Errorf("unexpected indexes %v", names)
+	}
+	if _, err := s.Put(comment{"2", "a", "bob", []string{"x"}}); err != nil {
+		t.Fatal(err)
+	}
+	for index, c := range map[string]struct {
+		key      Key
+		expected uint
+	}{
+		"byArticle": {Key{"a"}, 2},
+		"byAuthor":  {Key{"ann"}, 1},
+		"byTag":     {Key{"x"}, 2},
+	} {
+		if n, err := must(s.Index(index)).Count(Only(c.key)); err != nil || n != c.expected {
+			t.Errorf("%s: expected %d entries, got %d %v", index, c.expected, n, err)
+		}
+	}
+	db.Close()
+
+	type changed struct {
+		Author string `json:"author" idb:"byAuthor,unique"`
+	}
+	_, err = f.Open("db", 2).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.Store("comments")
+		if err != nil {
+			return err
+		}
+		return s.CreateIndexesFor(changed{})
+	})
+	if !errors.Is(err, ErrConstraint) {
+		t.Errorf("expected a changed definition to fail, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package indexeddb
+
+import (
+	"context"
+	"sync"
+)
+
+// scheduler orders read-write transactions. A transaction starts once no
+// transaction requested before it, running or waiting, shares a store with it.
+// Readonly transactions work on snapshots and are not scheduled.
+type scheduler struct {
+	mu    sync.Mutex
+	queue []*scheduled
+}
+
+type scheduled struct {
+	scope   map[string]struct{}
+	running bool
+	ready   chan struct{}
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{}
+}
+
+// acquire blocks until a transaction over scope may start, or until ctx is done.
+// The returned function must be called once the transaction has finished.
+func (p *scheduler) acquire(ctx context.Context, scope []string) (func(), error) {
+	entry := &scheduled{
+		scope: make(map[string]struct{}, len(scope)),
+		ready: make(chan struct{}),
+	}
+	for _, name := range scope {
+		entry.scope[name] = struct{}{}
+	}
+
+	p.mu.Lock()
+	p.queue = append(p.queue, entry)
+	p.start()
+	p.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		// the entry may have started meanwhile, either way it leaves the queue
+		p.release(entry)
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.release(entry)
+		})
+	}, nil
+}
+
+func (p *scheduler) release(entry *scheduled) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, e := range p.queue {
+		if e == entry {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			break
+		}
+	}
+	p.start()
+}
+
+// start marks every waiting transaction that no longer conflicts as running.
+// Callers must hold p.mu.
+func (p *scheduler) start() {
+	for i, e := range p.queue {
+		if e.running || p.blocked(i) {
+			continue
+		}
+		e.running = true
+		close(e.ready)
+	}
+}
+
+// blocked reports whether an earlier entry in the queue overlaps entry i
+func (p *scheduler) blocked(i int) bool {
+	for _, earlier := range p.queue[:i] {
+		for name := range p.queue[i].scope {
+			if _, ok := earlier.scope[name]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
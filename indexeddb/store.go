@@ -1,6 +1,8 @@
 package indexeddb
 
 import (
+	"context"
+
 	"github.com/huffduff/go-indexeddb/indexeddb/internal"
 )
 
@@ -8,18 +10,49 @@ import (
 type StoreOptions struct {
 
 	// keyPath – a path to an object property that IndexedDB will use as the key, e.g. id.
-	KeyPath string `json:"keyPath,omitempty"`
+	// Dots reach nested properties and several paths make a compound key.
+	KeyPath KeyPath `json:"keyPath,omitempty"`
 
 	// autoIncrement – if true, then the key for a newly stored object is generated automatically,
-	// as an ever-incrementing number.
+	// as an ever-incrementing number starting at 1. A key is generated when the key path is
+	// absent, nil or 0, or an empty struct field tagged omitempty.
 	AutoIncrement bool `json:"autoIncrement,omitempty"`
+
+	// Codec encodes the store's values. Without one the database's default is used, which is JSON
+	// unless set with Codec when opening.
+	Codec Codec `json:"-"`
+}
+
+// KeyValue is one record of a bulk write. Leave Key nil to use the store's
+// key path or key generator.
+type KeyValue = internal.KeyValue
+
+// BulkError reports the items of a bulk write that failed, by position
+type BulkError = internal.BulkError
+
+// Decoder decodes the value of the record a ForEach callback was called with.
+// It is only valid until the callback returns.
+type Decoder = internal.Decoder
+
+// GetAllOptions selects the records of GetAllRecords by range, direction, offset and count
+type GetAllOptions = internal.GetAllOptions
+
+// Entry is one record returned by GetAllRecords. Key is the index key for
+// indexes and the primary key for stores. Value decodes the record's value.
+type Entry = internal.Entry
+
+// BulkOptions controls how bulk writes handle failing items
+type BulkOptions struct {
+	// Atomic writes nothing if any item fails. Otherwise the other items are written.
+	Atomic bool
 }
 
 type Store interface {
 	Name() string
-	KeyPath() string
+	KeyPath() KeyPath
 	IndexNames() []string
 	AutoIncrement() bool
+	Codec() Codec
 }
 
 type ReadStore interface {
@@ -33,18 +66,49 @@ type ReadStore interface {
 	Count(query Range) (uint, error)
 	OpenCursor(query Range, direction Direction) (Cursor, error)
 	OpenKeyCursor(query Range, direction Direction) (KeyCursor, error)
-
-	Index(name string) *Index
+	ForEach(query Range, fn func(key Key, value Decoder) error) error
+	GetAllRecords(opts GetAllOptions) ([]Entry, error)
+	ResumeCursor(token string) (Cursor, error)
+
+	GetExactContext(ctx context.Context, key Key, val interface{}) error
+	GetContext(ctx context.Context, query Range, val interface{}) error
+	GetMultiContext(ctx context.Context, key []Key, val interface{}) error
+	GetAllContext(ctx context.Context, query Range, limit int, val interface{}) error
+	GetKeyContext(ctx context.Context, query Range) (Key, error)
+	GetAllKeysContext(ctx context.Context, query Range, limit int) ([]Key, error)
+	CountContext(ctx context.Context, query Range) (uint, error)
+	OpenCursorContext(ctx context.Context, query Range, direction Direction) (Cursor, error)
+	OpenKeyCursorContext(ctx context.Context, query Range, direction Direction) (KeyCursor, error)
+	ForEachContext(ctx context.Context, query Range, fn func(key Key, value Decoder) error) error
+	GetAllRecordsContext(ctx context.Context, opts GetAllOptions) ([]Entry, error)
+	ResumeCursorContext(ctx context.Context, token string) (Cursor, error)
+
+	Index(name string) (*Index, error)
 }
 
 type WriteStore interface {
 	ReadStore
-	// Put(value interface{}) error
+	Put(value interface{}) (Key, error)
 	PutWithKey(key Key, value interface{}) error
-	// Add(value interface{}) error
+	Add(value interface{}) (Key, error)
 	AddWithKey(key Key, value interface{}) error
-	Delete(key Key) error
+	Delete(query Range) error
+	DeleteExact(key Key) error
 	Clear() error
+	BulkPut(items []KeyValue, opts BulkOptions) ([]Key, error)
+	BulkAdd(items []KeyValue, opts BulkOptions) ([]Key, error)
+	BulkDelete(keys []Key, opts BulkOptions) error
+
+	PutContext(ctx context.Context, value interface{}) (Key, error)
+	PutWithKeyContext(ctx context.Context, key Key, value interface{}) error
+	AddContext(ctx context.Context, value interface{}) (Key, error)
+	AddWithKeyContext(ctx context.Context, key Key, value interface{}) error
+	DeleteContext(ctx context.Context, query Range) error
+	DeleteExactContext(ctx context.Context, key Key) error
+	ClearContext(ctx context.Context) error
+	BulkPutContext(ctx context.Context, items []KeyValue, opts BulkOptions) ([]Key, error)
+	BulkAddContext(ctx context.Context, items []KeyValue, opts BulkOptions) ([]Key, error)
+	BulkDeleteContext(ctx context.Context, keys []Key, opts BulkOptions) error
 }
 
 var _ Store = (*BaseStore)(nil)
@@ -65,7 +129,7 @@ func (p *BaseStore) Name() string {
 	return p.def.Name
 }
 
-func (p *BaseStore) KeyPath() string {
+func (p *BaseStore) KeyPath() KeyPath {
 	return p.def.KeyPath
 }
 
@@ -77,110 +141,480 @@ func (p *BaseStore) AutoIncrement() bool {
 	return p.def.AutoIncrement
 }
 
+// Codec returns the codec of the store's values
+func (p *BaseStore) Codec() Codec {
+	c, _ := internal.LookupCodec(p.def.Codec)
+	return c
+}
+
+// ReadonlyStore reads a store within a readonly transaction. Every read has a
+// Context variant that aborts the transaction and returns ctx.Err() once ctx is done.
 type ReadonlyStore struct {
 	BaseStore
 	Transaction *ReadonlyTransaction
 }
 
 func (p *ReadonlyStore) GetExact(key Key, v interface{}) error {
-	return p.def.GetExact(p.Transaction.h, key, v)
+	return p.GetExactContext(context.Background(), key, v)
+}
+
+func (p *ReadonlyStore) GetExactContext(ctx context.Context, key Key, v interface{}) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.GetExact(p.Transaction.h, key, v)
+	})
 }
 
 func (p *ReadonlyStore) Get(query Range, v interface{}) error {
-	return p.def.Get(p.Transaction.h, query, v)
+	return p.GetContext(context.Background(), query, v)
+}
+
+func (p *ReadonlyStore) GetContext(ctx context.Context, query Range, v interface{}) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.Get(p.Transaction.h, query, v)
+	})
 }
 
 func (p *ReadonlyStore) GetMulti(keys []Key, v interface{}) error {
-	return p.def.GetMulti(p.Transaction.h, keys, v)
+	return p.GetMultiContext(context.Background(), keys, v)
+}
+
+func (p *ReadonlyStore) GetMultiContext(ctx context.Context, keys []Key, v interface{}) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.GetMulti(p.Transaction.h, keys, v)
+	})
 }
 
 func (p *ReadonlyStore) GetAll(query Range, limit int, v interface{}) error {
-	return p.def.GetAll(p.Transaction.h, query, limit, v)
+	return p.GetAllContext(context.Background(), query, limit, v)
+}
+
+func (p *ReadonlyStore) GetAllContext(ctx context.Context, query Range, limit int, v interface{}) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.GetAll(p.Transaction.h, query, limit, v)
+	})
 }
 
 func (p *ReadonlyStore) GetKey(query Range) (Key, error) {
-	return p.def.GetKey(p.Transaction.h, query)
+	return p.GetKeyContext(context.Background(), query)
+}
+
+func (p *ReadonlyStore) GetKeyContext(ctx context.Context, query Range) (Key, error) {
+	var out Key
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.GetKey(p.Transaction.h, query)
+		return err
+	})
+	return out, err
 }
 
 func (p *ReadonlyStore) GetAllKeys(query Range, limit int) ([]Key, error) {
-	return p.def.GetAllKeys(p.Transaction.h, query, limit)
+	return p.GetAllKeysContext(context.Background(), query, limit)
+}
+
+func (p *ReadonlyStore) GetAllKeysContext(ctx context.Context, query Range, limit int) ([]Key, error) {
+	var out []Key
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.GetAllKeys(p.Transaction.h, query, limit)
+		return err
+	})
+	return out, err
 }
 
 func (p *ReadonlyStore) Count(query Range) (uint, error) {
-	return p.def.Count(p.Transaction.h, query)
+	return p.CountContext(context.Background(), query)
+}
+
+func (p *ReadonlyStore) CountContext(ctx context.Context, query Range) (uint, error) {
+	var out uint
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.Count(p.Transaction.h, query)
+		return err
+	})
+	return out, err
 }
 
 func (p *ReadonlyStore) OpenCursor(query Range, direction Direction) (Cursor, error) {
-	return p.def.GetCursor(p.Transaction.h, query, direction)
+	return p.OpenCursorContext(context.Background(), query, direction)
+}
+
+func (p *ReadonlyStore) OpenCursorContext(ctx context.Context, query Range, direction Direction) (Cursor, error) {
+	var out Cursor
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.GetCursor(p.Transaction.h, query, direction)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
 func (p *ReadonlyStore) OpenKeyCursor(query Range, direction Direction) (KeyCursor, error) {
-	return p.def.GetCursor(p.Transaction.h, query, direction)
+	return p.OpenKeyCursorContext(context.Background(), query, direction)
+}
+
+func (p *ReadonlyStore) OpenKeyCursorContext(ctx context.Context, query Range, direction Direction) (KeyCursor, error) {
+	var out KeyCursor
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.GetCursor(p.Transaction.h, query, direction)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ForEach calls fn with the key and a decoder for every record within query, in key
+// order. Records are read one at a time, so it suits stores too large for GetAll.
+// Returning ErrStop from fn ends the iteration early without an error.
+func (p *ReadonlyStore) ForEach(query Range, fn func(key Key, value Decoder) error) error {
+	return p.ForEachContext(context.Background(), query, fn)
+}
+
+func (p *ReadonlyStore) ForEachContext(ctx context.Context, query Range, fn func(key Key, value Decoder) error) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.ForEach(p.Transaction.h, query, fn)
+	})
 }
 
-func (p *ReadonlyStore) Index(name string) *Index {
-	idx := p.def.Indexes[name]
-	return &Index{idx, p, p.Transaction.h}
+// GetAllRecords returns the key and value of the records selected by opts,
+// in either direction and starting after an offset
+func (p *ReadonlyStore) GetAllRecords(opts GetAllOptions) ([]Entry, error) {
+	return p.GetAllRecordsContext(context.Background(), opts)
 }
 
+func (p *ReadonlyStore) GetAllRecordsContext(ctx context.Context, opts GetAllOptions) ([]Entry, error) {
+	var out []Entry
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.GetAllRecords(p.Transaction.h, opts)
+		return err
+	})
+	return out, err
+}
+
+// ResumeCursor opens a cursor from a token made by Cursor.Token on this store, possibly
+// in an earlier transaction. Its first Continue moves to the record after the token's,
+// keeping the range and direction of the cursor that made it.
+func (p *ReadonlyStore) ResumeCursor(token string) (Cursor, error) {
+	return p.ResumeCursorContext(context.Background(), token)
+}
+
+func (p *ReadonlyStore) ResumeCursorContext(ctx context.Context, token string) (Cursor, error) {
+	var out Cursor
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.ResumeCursor(p.Transaction.h, token)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Index returns an index of the store
+func (p *ReadonlyStore) Index(name string) (*Index, error) {
+	idx, ok := p.def.Indexes[name]
+	if !ok {
+		return nil, internal.NewError(NotFoundError, "index %s not found in store %s", name, p.def.Name)
+	}
+	return &Index{idx, p, p.Transaction.h, &p.Transaction.baseTransaction}, nil
+}
+
+// TransactionStore reads and writes a store within a read-write transaction. Every
+// operation has a Context variant that aborts the transaction and returns ctx.Err()
+// once ctx is done.
 type TransactionStore struct {
 	BaseStore
 	Transaction *Transaction
 }
 
+// Put stores value using the key found at the store's key path.
+// Auto incrementing stores generate a key when the value has none,
+// writing it back into the value if the store has a key path.
+func (p *TransactionStore) Put(value interface{}) (Key, error) {
+	return p.PutContext(context.Background(), value)
+}
+
+func (p *TransactionStore) PutContext(ctx context.Context, value interface{}) (Key, error) {
+	var out Key
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.PutInline(p.Transaction.h, value)
+		return err
+	})
+	return out, err
+}
+
 func (p *TransactionStore) PutWithKey(key Key, value interface{}) error {
-	return p.def.Put(p.Transaction.h, key, value)
+	return p.PutWithKeyContext(context.Background(), key, value)
+}
+
+func (p *TransactionStore) PutWithKeyContext(ctx context.Context, key Key, value interface{}) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.Put(p.Transaction.h, key, value)
+	})
+}
+
+// Add stores value like Put but fails if a record with that key already exists.
+func (p *TransactionStore) Add(value interface{}) (Key, error) {
+	return p.AddContext(context.Background(), value)
+}
+
+func (p *TransactionStore) AddContext(ctx context.Context, value interface{}) (Key, error) {
+	var out Key
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.AddInline(p.Transaction.h, value)
+		return err
+	})
+	return out, err
 }
 
 func (p *TransactionStore) AddWithKey(key Key, value interface{}) error {
-	return p.def.Add(p.Transaction.h, key, value)
+	return p.AddWithKeyContext(context.Background(), key, value)
+}
+
+func (p *TransactionStore) AddWithKeyContext(ctx context.Context, key Key, value interface{}) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.Add(p.Transaction.h, key, value)
+	})
 }
 
-func (p *TransactionStore) Delete(key Key) error {
-	return p.def.Delete(*p.Transaction.h, key)
+// Delete removes every record within query
+func (p *TransactionStore) Delete(query Range) error {
+	return p.DeleteContext(context.Background(), query)
 }
 
+func (p *TransactionStore) DeleteContext(ctx context.Context, query Range) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.Delete(p.Transaction.h, query)
+	})
+}
+
+// DeleteExact removes the record stored under key, if any
+func (p *TransactionStore) DeleteExact(key Key) error {
+	return p.DeleteExactContext(context.Background(), key)
+}
+
+func (p *TransactionStore) DeleteExactContext(ctx context.Context, key Key) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.DeleteExact(p.Transaction.h, key)
+	})
+}
+
+// Clear removes every record of the store
 func (p *TransactionStore) Clear() error {
-	return p.def.Clear(*p.Transaction.h)
+	return p.ClearContext(context.Background())
+}
+
+func (p *TransactionStore) ClearContext(ctx context.Context) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.Clear(p.Transaction.h)
+	})
+}
+
+// BulkPut stores every item in a single batch and returns their keys.
+// Failed items are reported in a *BulkError and have a nil key.
+func (p *TransactionStore) BulkPut(items []KeyValue, opts BulkOptions) ([]Key, error) {
+	return p.BulkPutContext(context.Background(), items, opts)
+}
+
+func (p *TransactionStore) BulkPutContext(ctx context.Context, items []KeyValue, opts BulkOptions) ([]Key, error) {
+	var out []Key
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.BulkPut(p.Transaction.h, items, opts.Atomic)
+		return err
+	})
+	return out, err
+}
+
+// BulkAdd stores every item like BulkPut, failing the items whose key already exists
+func (p *TransactionStore) BulkAdd(items []KeyValue, opts BulkOptions) ([]Key, error) {
+	return p.BulkAddContext(context.Background(), items, opts)
+}
+
+func (p *TransactionStore) BulkAddContext(ctx context.Context, items []KeyValue, opts BulkOptions) ([]Key, error) {
+	var out []Key
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.BulkAdd(p.Transaction.h, items, opts.Atomic)
+		return err
+	})
+	return out, err
+}
+
+// BulkDelete removes the records stored under keys in a single batch
+func (p *TransactionStore) BulkDelete(keys []Key, opts BulkOptions) error {
+	return p.BulkDeleteContext(context.Background(), keys, opts)
+}
+
+func (p *TransactionStore) BulkDeleteContext(ctx context.Context, keys []Key, opts BulkOptions) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.BulkDelete(p.Transaction.h, keys, opts.Atomic)
+	})
 }
 
 func (p *TransactionStore) GetExact(key Key, v interface{}) error {
-	return p.def.GetExact(p.Transaction.h, key, v)
+	return p.GetExactContext(context.Background(), key, v)
+}
+
+func (p *TransactionStore) GetExactContext(ctx context.Context, key Key, v interface{}) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.GetExact(p.Transaction.h, key, v)
+	})
 }
 
 func (p *TransactionStore) Get(query Range, v interface{}) error {
-	return p.def.Get(p.Transaction.h, query, v)
+	return p.GetContext(context.Background(), query, v)
+}
+
+func (p *TransactionStore) GetContext(ctx context.Context, query Range, v interface{}) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.Get(p.Transaction.h, query, v)
+	})
 }
 
 func (p *TransactionStore) GetMulti(keys []Key, v interface{}) error {
-	return p.def.GetMulti(p.Transaction.h, keys, v)
+	return p.GetMultiContext(context.Background(), keys, v)
+}
+
+func (p *TransactionStore) GetMultiContext(ctx context.Context, keys []Key, v interface{}) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.GetMulti(p.Transaction.h, keys, v)
+	})
 }
 
 func (p *TransactionStore) GetAll(query Range, limit int, v interface{}) error {
-	return p.def.GetAll(p.Transaction.h, query, limit, v)
+	return p.GetAllContext(context.Background(), query, limit, v)
+}
+
+func (p *TransactionStore) GetAllContext(ctx context.Context, query Range, limit int, v interface{}) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.GetAll(p.Transaction.h, query, limit, v)
+	})
 }
 
 func (p *TransactionStore) GetKey(query Range) (Key, error) {
-	return p.def.GetKey(p.Transaction.h, query)
+	return p.GetKeyContext(context.Background(), query)
+}
+
+func (p *TransactionStore) GetKeyContext(ctx context.Context, query Range) (Key, error) {
+	var out Key
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.GetKey(p.Transaction.h, query)
+		return err
+	})
+	return out, err
 }
 
 func (p *TransactionStore) GetAllKeys(query Range, limit int) ([]Key, error) {
-	return p.def.GetAllKeys(p.Transaction.h, query, limit)
+	return p.GetAllKeysContext(context.Background(), query, limit)
+}
+
+func (p *TransactionStore) GetAllKeysContext(ctx context.Context, query Range, limit int) ([]Key, error) {
+	var out []Key
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.GetAllKeys(p.Transaction.h, query, limit)
+		return err
+	})
+	return out, err
 }
 
 func (p *TransactionStore) Count(query Range) (uint, error) {
-	return p.def.Count(p.Transaction.h, query)
+	return p.CountContext(context.Background(), query)
+}
+
+func (p *TransactionStore) CountContext(ctx context.Context, query Range) (uint, error) {
+	var out uint
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.Count(p.Transaction.h, query)
+		return err
+	})
+	return out, err
 }
 
 func (p *TransactionStore) OpenCursor(query Range, direction Direction) (Cursor, error) {
-	return p.def.GetCursor(p.Transaction.h, query, direction)
+	return p.OpenCursorContext(context.Background(), query, direction)
 }
 
-func (p *TransactionStore) OpenKeyCursor(query Range, direction Direction) (KeyCursor, error) {
-	return p.def.GetCursor(p.Transaction.h, query, direction)
+func (p *TransactionStore) OpenCursorContext(ctx context.Context, query Range, direction Direction) (Cursor, error) {
+	var out Cursor
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.GetCursor(p.Transaction.h, query, direction)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func (p *TransactionStore) Index(name string) *Index {
-	idx := p.def.Indexes[name]
-	return &Index{idx, p, p.Transaction.h}
+func (p *TransactionStore) OpenKeyCursor(query Range, direction Direction) (KeyCursor, error) {
+	return p.OpenKeyCursorContext(context.Background(), query, direction)
+}
+
+func (p *TransactionStore) OpenKeyCursorContext(ctx context.Context, query Range, direction Direction) (KeyCursor, error) {
+	var out KeyCursor
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.GetCursor(p.Transaction.h, query, direction)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ForEach calls fn with the key and a decoder for every record within query, in key
+// order. Records are read one at a time, so it suits stores too large for GetAll.
+// Returning ErrStop from fn ends the iteration early without an error.
+func (p *TransactionStore) ForEach(query Range, fn func(key Key, value Decoder) error) error {
+	return p.ForEachContext(context.Background(), query, fn)
+}
+
+func (p *TransactionStore) ForEachContext(ctx context.Context, query Range, fn func(key Key, value Decoder) error) error {
+	return p.Transaction.run(ctx, func() error {
+		return p.def.ForEach(p.Transaction.h, query, fn)
+	})
+}
+
+// GetAllRecords returns the key and value of the records selected by opts,
+// in either direction and starting after an offset
+func (p *TransactionStore) GetAllRecords(opts GetAllOptions) ([]Entry, error) {
+	return p.GetAllRecordsContext(context.Background(), opts)
+}
+
+func (p *TransactionStore) GetAllRecordsContext(ctx context.Context, opts GetAllOptions) ([]Entry, error) {
+	var out []Entry
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.GetAllRecords(p.Transaction.h, opts)
+		return err
+	})
+	return out, err
+}
+
+// ResumeCursor opens a cursor from a token made by Cursor.Token on this store, possibly
+// in an earlier transaction. Its first Continue moves to the record after the token's,
+// keeping the range and direction of the cursor that made it.
+func (p *TransactionStore) ResumeCursor(token string) (Cursor, error) {
+	return p.ResumeCursorContext(context.Background(), token)
+}
+
+func (p *TransactionStore) ResumeCursorContext(ctx context.Context, token string) (Cursor, error) {
+	var out Cursor
+	err := p.Transaction.run(ctx, func() (err error) {
+		out, err = p.def.ResumeCursor(p.Transaction.h, token)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Index returns an index of the store
+func (p *TransactionStore) Index(name string) (*Index, error) {
+	idx, ok := p.def.Indexes[name]
+	if !ok {
+		return nil, internal.NewError(NotFoundError, "index %s not found in store %s", name, p.def.Name)
+	}
+	return &Index{idx, p, p.Transaction.h, &p.Transaction.baseTransaction}, nil
 }
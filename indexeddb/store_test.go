@@ -0,0 +1,421 @@
+package indexeddb
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type testRecord struct {
+	Id    string `json:"_id"`
+	Title string `json:"title"`
+}
+
+// must unwraps a value in tests where the error is not under test
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func openTestDatabase(t *testing.T, stores map[string]StoreOptions) *Database {
+	t.Helper()
+	db, err := Open("test", 1, t.TempDir()).Migrate(func(_ uint, h *MigrationTransaction) error {
+		for name, opts := range stores {
+			if _, err := h.CreateStore(name, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestPutWithKeyPath(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{"records": {KeyPath: KeyPath{"_id"}}})
+
+	tr, err := db.Transaction([]string{"records"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := must(tr.Store("records"))
+
+	key, err := s.Put(&testRecord{"a", "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key, Key{"a"}) {
+		t.Errorf("unexpected key %v", key)
+	}
+	if _, err := s.Put(map[string]interface{}{"_id": "b", "title": "second"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := db.ReadonlyTransaction([]string{"records"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+
+	for id, title := range map[string]string{"a": "first", "b": "second"} {
+		var out testRecord
+		if err := must(rt.Store("records")).GetExact(Key{id}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Title != title {
+			t.Errorf("expected %s, got %s", title, out.Title)
+		}
+	}
+}
+
+func TestPutInvalidKeyPath(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{
+		"records": {KeyPath: KeyPath{"_id"}},
+		"plain":   {},
+	})
+
+	tr, err := db.Transaction([]string{"records", "plain"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+
+	if _, err := must(tr.Store("records")).Put(map[string]interface{}{"title": "missing"}); err == nil {
+		t.Error("expected an error for a missing key path")
+	}
+	if _, err := must(tr.Store("records")).Put(map[string]interface{}{"_id": true}); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+	if _, err := must(tr.Store("plain")).Put(&testRecord{"a", "first"}); err == nil {
+		t.Error("expected an error for a store without a key path")
+	}
+}
+
+func TestAddWithKeyPath(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{"records": {KeyPath: KeyPath{"_id"}}})
+
+	tr, err := db.Transaction([]string{"records"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+
+	s := must(tr.Store("records"))
+	if _, err := s.Add(testRecord{"a", "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(testRecord{"a", "again"}); err == nil {
+		t.Error("expected adding an existing key to fail")
+	}
+
+	var out testRecord
+	if err := s.GetExact(Key{"a"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Title != "first" {
+		t.Errorf("expected the original record, got %s", out.Title)
+	}
+}
+
+func TestAutoIncrement(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{
+		"events": {AutoIncrement: true},
+		"inline": {KeyPath: KeyPath{"id"}, AutoIncrement: true},
+	})
+
+	tr, err := db.Transaction([]string{"events", "inline"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := must(tr.Store("events"))
+	for i := 1.0; i <= 2; i++ {
+		key, err := events.Put("event")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(key, Key{i}) {
+			t.Errorf("expected key %v, got %v", i, key)
+		}
+	}
+	if err := events.PutWithKey(Key{10.5}, "explicit"); err != nil {
+		t.Fatal(err)
+	}
+	key, err := events.Add("event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key, Key{11.0}) {
+		t.Errorf("generator should move past explicit keys, got %v", key)
+	}
+
+	type inline struct {
+		Id    int    `json:"id,omitempty"`
+		Title string `json:"title"`
+	}
+	rec := &inline{Title: "generated"}
+	if _, err := must(tr.Store("inline")).Put(rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Id != 1 {
+		t.Errorf("generated key should be written into the value, got %d", rec.Id)
+	}
+	var out inline
+	if err := must(tr.Store("inline")).GetExact(Key{1.0}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Id != 1 {
+		t.Errorf("stored value should contain the generated key, got %d", out.Id)
+	}
+	if _, err := must(tr.Store("inline")).Put(inline{Title: "by value"}); err == nil {
+		t.Error("expected an error when the generated key cannot be written back")
+	}
+	// a zero key is never generated, so it is replaced by the next key
+	if key, err := must(tr.Store("inline")).Put(map[string]interface{}{"id": 0.0}); err != nil || !reflect.DeepEqual(key, Key{2.0}) {
+		t.Errorf("expected a generated key for id 0, got %v %v", key, err)
+	}
+	type event struct {
+		Id int `json:"id"`
+	}
+	for _, expected := range []Key{{3.0}, {4.0}} {
+		rec := &event{}
+		if key, err := must(tr.Store("inline")).Put(rec); err != nil || !reflect.DeepEqual(key, expected) || rec.Id != int(expected[0].(float64)) {
+			t.Errorf("expected the generated key %v, got %v %d %v", expected, key, rec.Id, err)
+		}
+	}
+	if n, err := must(tr.Store("inline")).Count(All()); err != nil || n != 4 {
+		t.Errorf("expected records with a zero id to be added, got %d %v", n, err)
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err = db.Transaction([]string{"events"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := must(tr.Store("events")).Put("rolled back"); err != nil {
+		t.Fatal(err)
+	}
+	tr.Abort()
+
+	tr, err = db.Transaction([]string{"events"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+	key, err = must(tr.Store("events")).Put("event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key, Key{12.0}) {
+		t.Errorf("aborted transactions should roll back the generator, got %v", key)
+	}
+}
+
+func TestGeneratorFailedWrites(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{
+		"inline": {KeyPath: KeyPath{"id"}, AutoIncrement: true},
+	})
+	tr, err := db.Transaction([]string{"inline"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+	s := must(tr.Store("inline"))
+
+	if _, err := s.Add(map[string]interface{}{"id": 5.0}); err != nil {
+		t.Fatal(err)
+	}
+	// a failed Add must not move the generator past its key
+	if _, err := s.Add(map[string]interface{}{"id": 8.0, "ratio": math.Inf(1)}); err == nil {
+		t.Fatal("expected a value JSON cannot encode to fail")
+	}
+	if _, err := s.BulkPut([]KeyValue{
+		{Value: map[string]interface{}{}},
+		{Value: map[string]interface{}{"id": 20.0}},
+		{Value: "not an object"},
+	}, BulkOptions{Atomic: true}); err == nil {
+		t.Fatal("expected the atomic bulk write to fail")
+	}
+	// neither the generated nor the explicit key of the rolled back batch was used up
+	key, err := s.Put(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key, Key{6.0}) {
+		t.Errorf("expected key 6, got %v", key)
+	}
+	keys, err := s.BulkPut([]KeyValue{{Value: map[string]interface{}{}}, {Value: map[string]interface{}{}}}, BulkOptions{})
+	if err != nil || !reflect.DeepEqual(keys, []Key{{7.0}, {8.0}}) {
+		t.Errorf("expected keys generated within a batch to be distinct, got %v %v", keys, err)
+	}
+}
+
+func TestDeleteRange(t *testing.T) {
+	db := openTaskDatabase(t)
+
+	tr, err := db.Transaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := must(tr.Store("tasks"))
+	if err := s.Delete(Bound(Key{"b"}, Key{"c"}, false, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteExact(Key{"missing"}); err != nil {
+		t.Errorf("deleting a missing key should not fail, got %v", err)
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := db.ReadonlyTransaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+	keys, err := must(rt.Store("tasks")).GetAllKeys(All(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []Key{{"a"}, {"d"}}) {
+		t.Errorf("expected [a d] to remain, got %v", keys)
+	}
+	n, err := must(must(rt.Store("tasks")).Index("byStatus")).Count(All())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("index entries of deleted records should be removed, %d remain", n)
+	}
+}
+
+func TestClearRollback(t *testing.T) {
+	db := openTaskDatabase(t)
+
+	count := func() (uint, uint) {
+		t.Helper()
+		rt, err := db.ReadonlyTransaction([]string{"tasks"}, Default)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rt.Commit()
+		s := must(rt.Store("tasks"))
+		records, err := s.Count(All())
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := must(s.Index("byStatus")).Count(All())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return records, entries
+	}
+
+	tr, err := db.Transaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := must(tr.Store("tasks")).Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := must(tr.Store("tasks")).Count(All()); n != 0 {
+		t.Errorf("clear should be visible within the transaction, %d records remain", n)
+	}
+	tr.Abort()
+
+	if records, entries := count(); records != 4 || entries != 4 {
+		t.Errorf("aborted clear should keep every record, got %d records and %d index entries", records, entries)
+	}
+
+	tr, err = db.Transaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := must(tr.Store("tasks")).Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if records, entries := count(); records != 0 || entries != 0 {
+		t.Errorf("clear should remove every record, got %d records and %d index entries", records, entries)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	db := openTaskDatabase(t)
+
+	rt, err := db.ReadonlyTransaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+	s := must(rt.Store("tasks"))
+
+	var keys []Key
+	var tasks []testTask
+	err = s.ForEach(All(), func(key Key, value Decoder) error {
+		var task testTask
+		if err := value(&task); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		tasks = append(tasks, task)
+		if len(tasks) == 3 {
+			return ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []Key{{"a"}, {"b"}, {"c"}}) {
+		t.Errorf("expected keys [a b c], got %v", keys)
+	}
+	if !reflect.DeepEqual(tasks, []testTask{{"a", "open"}, {"b", "open"}, {"c", "stale"}}) {
+		t.Errorf("expected the first three tasks, got %v", tasks)
+	}
+
+	failed := errors.New("failed")
+	if err := s.ForEach(All(), func(Key, Decoder) error { return failed }); err != failed {
+		t.Errorf("expected the callback error, got %v", err)
+	}
+
+	var open []Key
+	err = must(s.Index("byStatus")).ForEach(Only(Key{"open"}), func(primaryKey Key, value Decoder) error {
+		var task testTask
+		if err := value(&task); err != nil {
+			return err
+		}
+		if task.Id != primaryKey[0] {
+			t.Errorf("expected the record of %v, got %v", primaryKey, task)
+		}
+		open = append(open, primaryKey)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(open, []Key{{"a"}, {"b"}, {"d"}}) {
+		t.Errorf("expected open tasks [a b d], got %v", open)
+	}
+
+	var limited []testTask
+	if err := s.GetAll(All(), 2, &limited); err != nil {
+		t.Fatal(err)
+	}
+	if len(limited) != 2 {
+		t.Errorf("expected GetAll to stop at its limit, got %v", limited)
+	}
+}
@@ -1,24 +1,89 @@
 package indexeddb
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/huffduff/go-indexeddb/indexeddb/internal"
-	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
 type TransactionDurability string
 
 const (
+	// Default uses the durability the database was opened with, Strict unless configured otherwise
 	Default TransactionDurability = "default"
-	Strict  TransactionDurability = "strict"
+	// Strict commits are synced to disk before Commit returns
+	Strict TransactionDurability = "strict"
+	// Relaxed commits may be lost if the machine crashes shortly after Commit returns.
+	// Write sets too large to buffer in memory are written as tables and always synced.
 	Relaxed TransactionDurability = "relaxed"
 )
 
+// synced reports whether commits are synced to disk, resolving Default to fallback
+func (d TransactionDurability) synced(fallback TransactionDurability) (bool, error) {
+	switch d {
+	case Strict:
+		return true, nil
+	case Relaxed:
+		return false, nil
+	case Default:
+		if fallback == Default || fallback == "" {
+			return true, nil
+		}
+		return fallback.synced(Strict)
+	}
+	return false, internal.NewError(DataError, "invalid durability %q", d)
+}
+
+// TransactionState is the lifecycle state of a transaction
+type TransactionState int
+
+const (
+	// TransactionActive transactions accept reads and writes
+	TransactionActive TransactionState = iota
+	// TransactionCommitting transactions are writing their changes
+	TransactionCommitting
+	// TransactionFinished transactions have been committed or aborted
+	TransactionFinished
+)
+
+func (s TransactionState) String() string {
+	switch s {
+	case TransactionActive:
+		return "active"
+	case TransactionCommitting:
+		return "committing"
+	case TransactionFinished:
+		return "finished"
+	}
+	return fmt.Sprintf("TransactionState(%d)", int(s))
+}
+
 type baseTransaction struct {
 	Durability TransactionDurability
 
 	stores map[string]Store
+	h      *internal.Transaction
+
+	// sync is the resolved durability of the commit
+	sync bool
+	// release lets the next transaction with an overlapping scope start
+	release func()
+	// ctx aborts the transaction once done, nil when the transaction has no context
+	ctx context.Context
+	// done is closed once the transaction finishes, stopping the watch of ctx
+	done chan struct{}
+
+	mu    sync.Mutex
+	state TransactionState
+	// calls counts the operations running, including nested ones
+	calls      int
+	committed  bool
+	onComplete func()
+	onAbort    func()
+	onError    func(err error)
 }
 
 func (p *baseTransaction) StoreNames() []string {
@@ -29,58 +94,217 @@ func (p *baseTransaction) StoreNames() []string {
 	return out
 }
 
-type ReadonlyTransaction struct {
-	baseTransaction
-	h *leveldb.Snapshot
+// State returns the lifecycle state of the transaction
+func (p *baseTransaction) State() TransactionState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
 }
 
-func (p *ReadonlyTransaction) Store(name string) *ReadonlyStore {
-	// what happens if the store isn't in the state?
-	return p.stores[name].(*ReadonlyStore)
+// OnComplete registers a callback fired once the transaction has been committed
+func (p *baseTransaction) OnComplete(cb func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onComplete = cb
 }
 
-func (p *ReadonlyTransaction) Abort() {
-	p.h.Release()
+// OnAbort registers a callback fired once the transaction has been aborted,
+// either explicitly or because committing it failed
+func (p *baseTransaction) OnAbort(cb func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onAbort = cb
 }
 
-func (p *ReadonlyTransaction) Commit() {
-	p.h.Release()
+// OnError registers a callback fired with the error that caused the
+// transaction to abort, before the abort callback
+func (p *baseTransaction) OnError(cb func(err error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onError = cb
 }
 
-type Transaction struct {
-	baseTransaction
-	h *leveldb.Transaction
+// Abort discards the changes of the transaction
+func (p *baseTransaction) Abort() error {
+	return p.abort(nil)
 }
 
-func (p *Transaction) Store(name string) *TransactionStore {
-	// what happens if the store isn't in the state?
-	return p.stores[name].(*TransactionStore)
+// Commit writes the changes of the transaction.
+// A failed commit aborts the transaction.
+func (p *baseTransaction) Commit() error {
+	p.mu.Lock()
+	if p.state != TransactionActive {
+		p.mu.Unlock()
+		return ErrTransactionInactive
+	}
+	p.state = TransactionCommitting
+	p.mu.Unlock()
+
+	err := p.h.Commit(&opt.WriteOptions{Sync: p.sync})
+	if err != nil {
+		p.h.Discard()
+		p.finish(false, err)
+		return err
+	}
+	p.finish(true, nil)
+	return nil
+}
+
+// setContext makes the transaction abort once ctx is done, even while it is idle,
+// so it releases its place in the scheduler
+func (p *baseTransaction) setContext(ctx context.Context) {
+	p.ctx = ctx
+	p.h.SetContext(ctx)
+	if ctx.Done() == nil {
+		return
+	}
+	p.done = make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// a running operation aborts the transaction itself once it returns
+			p.discard(ctx.Err(), true)
+		case <-p.done:
+		}
+	}()
 }
 
-func (p *Transaction) Abort() {
+// run calls fn, cancelling its reads and writes once ctx or the transaction's
+// context is done. In that case the transaction is aborted and the context's
+// error is returned.
+func (p *baseTransaction) run(ctx context.Context, fn func() error) error {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+
+	restore := p.h.CallContext(ctx)
+	err := fn()
+	restore()
+
+	p.mu.Lock()
+	p.calls--
+	outermost := p.calls == 0
+	p.mu.Unlock()
+
+	if ctxErr := internal.ContextErr(ctx, p.ctx); ctxErr != nil {
+		if outermost {
+			p.abort(ctxErr)
+		}
+		return ctxErr
+	}
+	return err
+}
+
+// abort discards the transaction, reporting cause to the error callback when set
+func (p *baseTransaction) abort(cause error) error {
+	return p.discard(cause, false)
+}
+
+// discard aborts the transaction like abort. With idle set it leaves the
+// transaction alone while an operation is running.
+func (p *baseTransaction) discard(cause error, idle bool) error {
+	p.mu.Lock()
+	if p.state != TransactionActive || (idle && p.calls > 0) {
+		p.mu.Unlock()
+		return ErrTransactionInactive
+	}
+	p.state = TransactionFinished
+	// discarded under the lock so no operation starts meanwhile
 	p.h.Discard()
+	p.mu.Unlock()
+
+	p.finish(false, cause)
+	return nil
+}
+
+// finish moves the transaction to its final state and fires the callbacks
+func (p *baseTransaction) finish(committed bool, cause error) {
+	p.mu.Lock()
+	p.state = TransactionFinished
+	p.committed = committed
+	release, onComplete, onAbort, onError := p.release, p.onComplete, p.onAbort, p.onError
+	p.mu.Unlock()
+
+	if p.done != nil {
+		close(p.done)
+	}
+
+	if release != nil {
+		release()
+	}
+	switch {
+	case committed:
+		if onComplete != nil {
+			onComplete()
+		}
+	default:
+		if cause != nil && onError != nil {
+			onError(cause)
+		}
+		if onAbort != nil {
+			onAbort()
+		}
+	}
+}
+
+type ReadonlyTransaction struct {
+	baseTransaction
+}
+
+// Store returns a store from the transaction scope
+func (p *ReadonlyTransaction) Store(name string) (*ReadonlyStore, error) {
+	store, ok := p.stores[name]
+	if !ok {
+		return nil, internal.NewError(NotFoundError, "store %s not found in transaction scope", name)
+	}
+	return store.(*ReadonlyStore), nil
+}
+
+type Transaction struct {
+	baseTransaction
 }
 
-func (p *Transaction) Commit() error {
-	return p.h.Commit()
+// Store returns a store from the transaction scope
+func (p *Transaction) Store(name string) (*TransactionStore, error) {
+	store, ok := p.stores[name]
+	if !ok {
+		return nil, internal.NewError(NotFoundError, "store %s not found in transaction scope", name)
+	}
+	return store.(*TransactionStore), nil
+}
+
+// checkScope ensures every store in scope exists and drops duplicates
+func checkScope(db *internal.Database, scope []string) ([]string, error) {
+	out := make([]string, 0, len(scope))
+	seen := make(map[string]struct{}, len(scope))
+	for _, name := range scope {
+		if _, ok := db.Stores[name]; !ok {
+			return nil, internal.NewError(NotFoundError, "store %s not found", name)
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		out = append(out, name)
+	}
+	return out, nil
 }
 
 func newReadonlyTransaction(db *internal.Database, scope []string, durability TransactionDurability) (*ReadonlyTransaction, error) {
-	h, err := db.GetSnapshot()
+	h, err := db.NewReadonlyTransaction()
 	if err != nil {
 		return nil, err
 	}
-	t := &ReadonlyTransaction{
-		baseTransaction{
-			durability,
-			make(map[string]Store, len(scope)),
-		},
-		h,
-	}
+	t := &ReadonlyTransaction{baseTransaction{
+		Durability: durability,
+		stores:     make(map[string]Store, len(scope)),
+		h:          h,
+	}}
 	for _, row := range scope {
 		store, ok := db.Stores[row]
 		if !ok {
-			return nil, fmt.Errorf("store %s not found", row)
+			h.Discard()
+			return nil, internal.NewError(NotFoundError, "store %s not found", row)
 		}
 		t.stores[row] = &ReadonlyStore{BaseStore{store}, t}
 	}
@@ -88,21 +312,20 @@ func newReadonlyTransaction(db *internal.Database, scope []string, durability Tr
 }
 
 func newTransaction(db *internal.Database, scope []string, durability TransactionDurability) (*Transaction, error) {
-	h, err := db.OpenTransaction()
+	h, err := db.NewTransaction()
 	if err != nil {
 		return nil, err
 	}
-	t := &Transaction{
-		baseTransaction{
-			durability,
-			make(map[string]Store, len(scope)),
-		},
-		h,
-	}
+	t := &Transaction{baseTransaction{
+		Durability: durability,
+		stores:     make(map[string]Store, len(scope)),
+		h:          h,
+	}}
 	for _, row := range scope {
 		store, ok := db.Stores[row]
 		if !ok {
-			return nil, fmt.Errorf("store %s not found", row)
+			h.Discard()
+			return nil, internal.NewError(NotFoundError, "store %s not found", row)
 		}
 		t.stores[row] = &TransactionStore{BaseStore{store}, t}
 	}
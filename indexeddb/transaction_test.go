@@ -0,0 +1,458 @@
+package indexeddb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStoreOutOfScope(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{"a": {}, "b": {}})
+
+	tr, err := db.Transaction([]string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Abort()
+	if _, err := tr.Store("b"); err == nil {
+		t.Error("expected an error for a store outside the scope")
+	}
+
+	rt, err := db.ReadonlyTransaction([]string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Abort()
+	if _, err := rt.Store("b"); err == nil {
+		t.Error("expected an error for a store outside the scope")
+	}
+
+	if _, err := db.Transaction([]string{"missing"}, Default); err == nil {
+		t.Error("expected an error for an unknown store")
+	}
+}
+
+func TestTransactionScheduling(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{"a": {}})
+
+	first, err := db.Transaction([]string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := must(first.Store("a")).PutWithKey(Key{"k"}, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan *Transaction)
+	go func() {
+		second, err := db.Transaction([]string{"a"}, Default)
+		if err != nil {
+			t.Error(err)
+		}
+		started <- second
+	}()
+
+	select {
+	case <-started:
+		t.Fatal("overlapping transaction started before the first one finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// readonly transactions do not wait and read the last committed state
+	rt, err := db.ReadonlyTransaction([]string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out string
+	if err := must(rt.Store("a")).GetExact(Key{"k"}, &out); err == nil {
+		t.Errorf("readonly transaction should not see uncommitted writes, got %s", out)
+	}
+	rt.Commit()
+
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var second *Transaction
+	select {
+	case second = <-started:
+	case <-time.After(time.Second):
+		t.Fatal("overlapping transaction did not start after the first one committed")
+	}
+	defer second.Abort()
+
+	if err := must(second.Store("a")).GetExact(Key{"k"}, &out); err != nil || out != "first" {
+		t.Errorf("expected the committed value, got %q (%v)", out, err)
+	}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	s := newScheduler()
+
+	releaseA := must(s.acquire(context.Background(), []string{"a"}))
+	releaseB := must(s.acquire(context.Background(), []string{"b"}))
+
+	acquired := func(scope ...string) chan func() {
+		ch := make(chan func(), 1)
+		go func() { ch <- must(s.acquire(context.Background(), scope)) }()
+		return ch
+	}
+
+	both := acquired("a", "b")
+	time.Sleep(20 * time.Millisecond)
+	// queued behind the waiting transaction even though b is released first
+	onlyB := acquired("b")
+	time.Sleep(20 * time.Millisecond)
+
+	releaseB()
+	select {
+	case <-both:
+		t.Fatal("transaction started while part of its scope was in use")
+	case <-onlyB:
+		t.Fatal("transaction started before an earlier overlapping one")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	releaseA()
+	var releaseBoth func()
+	select {
+	case releaseBoth = <-both:
+	case <-time.After(time.Second):
+		t.Fatal("transaction did not start once its scope was free")
+	}
+	select {
+	case <-onlyB:
+		t.Fatal("transaction started while its scope was in use")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	releaseBoth()
+	select {
+	case release := <-onlyB:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("transaction did not start once its scope was free")
+	}
+}
+
+func TestTransactionLifecycle(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{"a": {}})
+
+	tr, err := db.Transaction([]string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	tr.OnComplete(func() { events = append(events, "complete") })
+	tr.OnAbort(func() { events = append(events, "abort") })
+
+	if tr.State() != TransactionActive {
+		t.Errorf("expected an active transaction, got %s", tr.State())
+	}
+	s := must(tr.Store("a"))
+	if err := s.PutWithKey(Key{"k"}, "v"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if tr.State() != TransactionFinished {
+		t.Errorf("expected a finished transaction, got %s", tr.State())
+	}
+	if err := s.PutWithKey(Key{"k"}, "late"); !errors.Is(err, ErrTransactionInactive) {
+		t.Errorf("expected writes after commit to fail, got %v", err)
+	}
+	var out string
+	if err := s.GetExact(Key{"k"}, &out); !errors.Is(err, ErrTransactionInactive) {
+		t.Errorf("expected reads after commit to fail, got %v", err)
+	}
+	if err := tr.Commit(); !errors.Is(err, ErrTransactionInactive) {
+		t.Errorf("expected a second commit to fail, got %v", err)
+	}
+	if err := tr.Abort(); !errors.Is(err, ErrTransactionInactive) {
+		t.Errorf("expected abort after commit to fail, got %v", err)
+	}
+
+	rt, err := db.ReadonlyTransaction([]string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.OnComplete(func() { events = append(events, "readonly complete") })
+	c, err := must(rt.Store("a")).OpenCursor(All(), Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Continue() {
+		t.Error("cursors should stop once their transaction has finished")
+	}
+	if err := rt.Commit(); !errors.Is(err, ErrTransactionInactive) {
+		t.Errorf("expected a second commit to fail, got %v", err)
+	}
+
+	tr, err = db.Transaction([]string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.OnComplete(func() { events = append(events, "complete") })
+	tr.OnAbort(func() { events = append(events, "abort") })
+	if err := tr.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"complete", "readonly complete", "abort"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected callbacks %v, got %v", expected, events)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{"a": {}})
+
+	err := db.Update([]string{"a"}, Default, func(tx *Transaction) error {
+		return must(tx.Store("a")).PutWithKey(Key{"committed"}, "v")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	var reported error
+	aborted := false
+	err = db.Update([]string{"a"}, Default, func(tx *Transaction) error {
+		tx.OnError(func(err error) { reported = err })
+		tx.OnAbort(func() { aborted = true })
+		if err := must(tx.Store("a")).PutWithKey(Key{"failed"}, "v"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed || reported != failed || !aborted {
+		t.Errorf("expected the callback error to abort the transaction, got %v, %v, %v", err, reported, aborted)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("expected the panic to be passed on, got %v", r)
+			}
+		}()
+		db.Update([]string{"a"}, Default, func(tx *Transaction) error {
+			must(tx.Store("a")).PutWithKey(Key{"panicked"}, "v")
+			panic("boom")
+		})
+	}()
+
+	err = db.Update([]string{"a"}, Default, func(tx *Transaction) error {
+		return tx.Abort()
+	})
+	if !errors.Is(err, ErrAbort) {
+		t.Errorf("expected an aborted error, got %v", err)
+	}
+
+	rt, err := db.ReadonlyTransaction([]string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+	keys, err := must(rt.Store("a")).GetAllKeys(All(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []Key{{"committed"}}) {
+		t.Errorf("only the committed update should be stored, got %v", keys)
+	}
+}
+
+func TestTransactionContext(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{"a": {}})
+	err := db.Update([]string{"a"}, Default, func(tx *Transaction) error {
+		s := must(tx.Store("a"))
+		for _, k := range []string{"a", "b", "c"} {
+			if err := s.PutWithKey(Key{k}, k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a cancelled call aborts the transaction
+	tr, err := db.Transaction([]string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reported error
+	aborted := false
+	tr.OnError(func(err error) { reported = err })
+	tr.OnAbort(func() { aborted = true })
+	s := must(tr.Store("a"))
+	if err := s.PutWithKey(Key{"d"}, "d"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out []string
+	if err := s.GetAllContext(ctx, All(), 0, &out); err != context.Canceled {
+		t.Errorf("expected the call to be cancelled, got %v", err)
+	}
+	if reported != context.Canceled || !aborted || tr.State() != TransactionFinished {
+		t.Errorf("expected the transaction to abort, got %v, %v, %s", reported, aborted, tr.State())
+	}
+
+	// a cursor opened with a context stops once it is cancelled
+	rt, err := db.ReadonlyTransaction([]string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := must(must(rt.Store("a")).Count(All())); n != 3 {
+		t.Errorf("expected the aborted write to be discarded, got %d records", n)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	c, err := must(rt.Store("a")).OpenCursorContext(ctx, All(), Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Continue() {
+		t.Fatal("expected a first record")
+	}
+	cancel()
+	if c.Continue() {
+		t.Error("expected the cursor to stop once its context was cancelled")
+	}
+	if _, err := must(rt.Store("a")).Count(All()); err != nil {
+		t.Errorf("expected other calls to be unaffected, got %v", err)
+	}
+	rt.Commit()
+
+	// a transaction context applies to every call
+	ctx, cancel = context.WithCancel(context.Background())
+	rt, err = db.ReadonlyTransactionContext(ctx, []string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := must(rt.Store("a")).Count(All()); err != context.Canceled {
+		t.Errorf("expected the transaction context to cancel the call, got %v", err)
+	}
+	if rt.State() != TransactionFinished {
+		t.Errorf("expected the transaction to abort, got %s", rt.State())
+	}
+	if _, err := db.ReadonlyTransactionContext(ctx, []string{"a"}, Default); err != context.Canceled {
+		t.Errorf("expected a cancelled context to be refused, got %v", err)
+	}
+
+	// waiting for an overlapping transaction respects the deadline
+	first, err := db.Transaction([]string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := db.TransactionContext(ctx, []string{"a"}, Default); err != context.DeadlineExceeded {
+		t.Errorf("expected the wait to time out, got %v", err)
+	}
+	first.Abort()
+	err = db.UpdateContext(ctx, []string{"a"}, Default, func(tx *Transaction) error {
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected an expired context to be refused, got %v", err)
+	}
+	// the timed out wait must not block later transactions
+	err = db.Update([]string{"a"}, Default, func(tx *Transaction) error {
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTransactionContextIdle(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{"a": {}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr, err := db.TransactionContext(ctx, []string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aborted := make(chan error, 1)
+	tr.OnError(func(err error) { aborted <- err })
+
+	// cancelling an idle transaction aborts it and lets waiting transactions start
+	cancel()
+	select {
+	case err := <-aborted:
+		if err != context.Canceled {
+			t.Errorf("expected the context error to be reported, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the idle transaction to abort")
+	}
+	wait, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	next, err := db.TransactionContext(wait, []string{"a"}, Default)
+	if err != nil {
+		t.Fatalf("expected the scheduler slot to be released, got %v", err)
+	}
+	next.Abort()
+	if _, err := must(tr.Store("a")).Count(All()); err != context.Canceled {
+		t.Errorf("expected later calls to return the context error, got %v", err)
+	}
+}
+
+func TestTransactionContextCursor(t *testing.T) {
+	db := openTestDatabase(t, map[string]StoreOptions{"a": {}})
+	tr := must(db.Transaction([]string{"a"}, Default))
+	for i := 0; i < 1000; i++ {
+		if err := must(tr.Store("a")).PutWithKey(Key{float64(i)}, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ro, err := db.ReadonlyTransactionContext(ctx, []string{"a"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aborted := make(chan error, 1)
+	ro.OnError(func(err error) { aborted <- err })
+	cursor, err := must(ro.Store("a")).OpenCursor(All(), Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the idle abort runs while the cursor steps, open cursors stop
+	seen := 0
+	for cursor.Continue() {
+		var v int
+		if err := cursor.Value(&v); err != nil {
+			t.Fatal(err)
+		}
+		if seen++; seen == 10 {
+			cancel()
+		}
+	}
+	select {
+	case err := <-aborted:
+		if err != context.Canceled {
+			t.Errorf("expected the context error to be reported, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the transaction to abort")
+	}
+	if seen >= 1000 {
+		t.Errorf("expected the cursor to stop once the context is done, saw %d records", seen)
+	}
+}
@@ -0,0 +1,484 @@
+package indexeddb
+
+import (
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/huffduff/go-indexeddb/indexeddb/internal"
+)
+
+// KeyTypes are the Go types typed stores and indexes use for keys.
+// Numbers are stored as float64, so integers beyond 2^53 lose precision.
+// Use Key for compound keys.
+type KeyTypes interface {
+	~string | ~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~float32 | ~float64 |
+		time.Time | Key
+}
+
+// KeyOf converts a typed key to a Key. Times are converted to UTC, so the
+// same instant gives the same key in every location.
+func KeyOf[K KeyTypes](k K) Key {
+	switch t := interface{}(k).(type) {
+	case Key:
+		return t
+	case time.Time:
+		return Key{t.UTC()}
+	}
+	v := reflect.ValueOf(k)
+	switch v.Kind() {
+	case reflect.String:
+		return Key{v.String()}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Key{float64(v.Int())}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Key{float64(v.Uint())}
+	}
+	return Key{v.Float()}
+}
+
+// keysOf converts typed keys to Keys
+func keysOf[K KeyTypes](keys []K) []Key {
+	out := make([]Key, len(keys))
+	for i, k := range keys {
+		out[i] = KeyOf(k)
+	}
+	return out
+}
+
+// keyAs converts a stored key back to K. Numbers must fit K exactly, so integer
+// types refuse fractions and values out of their range.
+func keyAs[K KeyTypes](key Key) (K, error) {
+	var out K
+	if k, ok := interface{}(key).(K); ok {
+		return k, nil
+	}
+	if len(key) != 1 {
+		return out, internal.NewError(DataError, "key %v is not a %T", key, out)
+	}
+	v := reflect.ValueOf(key[0])
+	t := reflect.TypeOf(out)
+	if !v.IsValid() || !v.Type().ConvertibleTo(t) || (v.Kind() == reflect.String) != (t.Kind() == reflect.String) {
+		return out, internal.NewError(DataError, "key %v is not a %T", key, out)
+	}
+	if v.Kind() == reflect.Float64 && !fitsNumber(v.Float(), reflect.Zero(t)) {
+		return out, internal.NewError(DataError, "key %v does not fit a %T", key, out)
+	}
+	return v.Convert(t).Interface().(K), nil
+}
+
+// fitsNumber reports whether f converts to the kind of v without losing its value
+func fitsNumber(f float64, v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 && !v.OverflowInt(int64(f))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 && !v.OverflowUint(uint64(f))
+	case reflect.Float32:
+		return !v.OverflowFloat(f)
+	}
+	return true
+}
+
+// keysAs converts stored keys back to K
+func keysAs[K KeyTypes](keys []Key) ([]K, error) {
+	out := make([]K, len(keys))
+	for i, key := range keys {
+		var err error
+		out[i], err = keyAs[K](key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// TypedEntry is one record returned by GetAllRecords of a typed store or index.
+// Key is the index key for indexes and the primary key for stores.
+type TypedEntry[K KeyTypes, V any] struct {
+	Key        K
+	PrimaryKey Key
+	Value      V
+}
+
+// typedEntries decodes the keys and values of entries
+func typedEntries[K KeyTypes, V any](entries []Entry) ([]TypedEntry[K, V], error) {
+	out := make([]TypedEntry[K, V], len(entries))
+	for i, entry := range entries {
+		key, err := keyAs[K](entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = TypedEntry[K, V]{Key: key, PrimaryKey: entry.PrimaryKey}
+		err = entry.Value(&out[i].Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// TypedStore reads and writes values of type V stored under keys of type K.
+// Writes fail with a ReadOnlyError when the underlying store is readonly.
+type TypedStore[K KeyTypes, V any] struct {
+	store ReadStore
+}
+
+// NewTypedStore wraps a store of a readonly or a read-write transaction
+func NewTypedStore[K KeyTypes, V any](s ReadStore) *TypedStore[K, V] {
+	return &TypedStore[K, V]{s}
+}
+
+// Store returns the wrapped store
+func (p *TypedStore[K, V]) Store() ReadStore {
+	return p.store
+}
+
+func (p *TypedStore[K, V]) writer() (WriteStore, error) {
+	w, ok := p.store.(WriteStore)
+	if !ok {
+		return nil, internal.NewError(ReadOnlyError, "store %s belongs to a readonly transaction", p.store.Name())
+	}
+	return w, nil
+}
+
+// Get returns the value stored under key
+func (p *TypedStore[K, V]) Get(key K) (V, error) {
+	var out V
+	err := p.store.GetExact(KeyOf(key), &out)
+	return out, err
+}
+
+// GetFirst returns the first value within query
+func (p *TypedStore[K, V]) GetFirst(query Range) (V, error) {
+	var out V
+	err := p.store.Get(query, &out)
+	return out, err
+}
+
+// GetAll returns the values within query. A limit of 0 returns every value.
+func (p *TypedStore[K, V]) GetAll(query Range, limit int) ([]V, error) {
+	var out []V
+	err := p.store.GetAll(query, limit, &out)
+	return out, err
+}
+
+// GetMulti returns the values stored under keys, with a zero value for keys without a record
+func (p *TypedStore[K, V]) GetMulti(keys []K) ([]V, error) {
+	var out []V
+	err := p.store.GetMulti(keysOf(keys), &out)
+	return out, err
+}
+
+// GetKey returns the first key within query
+func (p *TypedStore[K, V]) GetKey(query Range) (K, error) {
+	key, err := p.store.GetKey(query)
+	if err != nil {
+		var out K
+		return out, err
+	}
+	return keyAs[K](key)
+}
+
+// GetAllKeys returns the keys within query. A limit of 0 returns every key.
+func (p *TypedStore[K, V]) GetAllKeys(query Range, limit int) ([]K, error) {
+	keys, err := p.store.GetAllKeys(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	return keysAs[K](keys)
+}
+
+func (p *TypedStore[K, V]) Count(query Range) (uint, error) {
+	return p.store.Count(query)
+}
+
+// ForEach calls fn with every key and value within query, decoding one record at a time.
+// Returning ErrStop from fn ends the iteration early without an error.
+func (p *TypedStore[K, V]) ForEach(query Range, fn func(key K, value V) error) error {
+	return p.store.ForEach(query, func(key Key, decode Decoder) error {
+		k, err := keyAs[K](key)
+		if err != nil {
+			return err
+		}
+		var value V
+		err = decode(&value)
+		if err != nil {
+			return err
+		}
+		return fn(k, value)
+	})
+}
+
+// GetAllRecords returns the key and value of the records selected by opts
+func (p *TypedStore[K, V]) GetAllRecords(opts GetAllOptions) ([]TypedEntry[K, V], error) {
+	entries, err := p.store.GetAllRecords(opts)
+	if err != nil {
+		return nil, err
+	}
+	return typedEntries[K, V](entries)
+}
+
+// Put stores value under the key found at the store's key path or generated for it
+func (p *TypedStore[K, V]) Put(value V) (K, error) {
+	var out K
+	w, err := p.writer()
+	if err != nil {
+		return out, err
+	}
+	// a pointer lets generated keys be written back into struct values
+	key, err := w.Put(&value)
+	if err != nil {
+		return out, err
+	}
+	return keyAs[K](key)
+}
+
+// PutWithKey stores value under key
+func (p *TypedStore[K, V]) PutWithKey(key K, value V) error {
+	w, err := p.writer()
+	if err != nil {
+		return err
+	}
+	return w.PutWithKey(KeyOf(key), value)
+}
+
+// Add stores value like Put but fails if a record with that key already exists
+func (p *TypedStore[K, V]) Add(value V) (K, error) {
+	var out K
+	w, err := p.writer()
+	if err != nil {
+		return out, err
+	}
+	key, err := w.Add(&value)
+	if err != nil {
+		return out, err
+	}
+	return keyAs[K](key)
+}
+
+// AddWithKey stores value under key, failing if a record with that key already exists
+func (p *TypedStore[K, V]) AddWithKey(key K, value V) error {
+	w, err := p.writer()
+	if err != nil {
+		return err
+	}
+	return w.AddWithKey(KeyOf(key), value)
+}
+
+// Delete removes every record within query
+func (p *TypedStore[K, V]) Delete(query Range) error {
+	w, err := p.writer()
+	if err != nil {
+		return err
+	}
+	return w.Delete(query)
+}
+
+// DeleteExact removes the record stored under key, if any
+func (p *TypedStore[K, V]) DeleteExact(key K) error {
+	w, err := p.writer()
+	if err != nil {
+		return err
+	}
+	return w.DeleteExact(KeyOf(key))
+}
+
+// OpenCursor iterates the values within query
+func (p *TypedStore[K, V]) OpenCursor(query Range, direction Direction) (*TypedCursor[K, V], error) {
+	c, err := p.store.OpenCursor(query, direction)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedCursor[K, V]{c}, nil
+}
+
+// ResumeCursor opens a cursor from a token made by a cursor of the same store
+func (p *TypedStore[K, V]) ResumeCursor(token string) (*TypedCursor[K, V], error) {
+	c, err := p.store.ResumeCursor(token)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedCursor[K, V]{c}, nil
+}
+
+// TypedCursor iterates a store, decoding keys as K and values as V
+type TypedCursor[K KeyTypes, V any] struct {
+	Cursor Cursor
+}
+
+func (p *TypedCursor[K, V]) Continue() bool {
+	return p.Cursor.Continue()
+}
+
+func (p *TypedCursor[K, V]) Advance(count int) bool {
+	return p.Cursor.Advance(count)
+}
+
+// ContinueTo moves the cursor to key, or to the next record after it in the cursor's direction
+func (p *TypedCursor[K, V]) ContinueTo(key K) error {
+	return p.Cursor.ContinueTo(KeyOf(key))
+}
+
+// Key returns the key at the cursor's position
+func (p *TypedCursor[K, V]) Key() (K, error) {
+	key, err := p.Cursor.Key()
+	if err != nil {
+		var out K
+		return out, err
+	}
+	return keyAs[K](key)
+}
+
+// Token returns an opaque token for the cursor's position, see TypedStore.ResumeCursor
+func (p *TypedCursor[K, V]) Token() (string, error) {
+	return p.Cursor.Token()
+}
+
+// Value returns the value at the cursor's position
+func (p *TypedCursor[K, V]) Value() (V, error) {
+	var out V
+	err := p.Cursor.Value(&out)
+	return out, err
+}
+
+// Update replaces the value at the cursor's position
+func (p *TypedCursor[K, V]) Update(value V) error {
+	return p.Cursor.Update(value)
+}
+
+// Delete removes the record at the cursor's position
+func (p *TypedCursor[K, V]) Delete() error {
+	return p.Cursor.Delete()
+}
+
+// TypedIndex reads the values of type V referenced by an index with keys of type IK
+type TypedIndex[IK KeyTypes, V any] struct {
+	index *Index
+}
+
+// NewTypedIndex wraps an index
+func NewTypedIndex[IK KeyTypes, V any](idx *Index) *TypedIndex[IK, V] {
+	return &TypedIndex[IK, V]{idx}
+}
+
+// Index returns the wrapped index
+func (p *TypedIndex[IK, V]) Index() *Index {
+	return p.index
+}
+
+// Get returns the value referenced by key
+func (p *TypedIndex[IK, V]) Get(key IK) (V, error) {
+	var out V
+	err := p.index.GetExact(KeyOf(key), &out)
+	return out, err
+}
+
+// GetFirst returns the first value referenced within query
+func (p *TypedIndex[IK, V]) GetFirst(query Range) (V, error) {
+	var out V
+	err := p.index.Get(query, &out)
+	return out, err
+}
+
+// GetAll returns the values referenced within query. A limit of 0 returns every value.
+func (p *TypedIndex[IK, V]) GetAll(query Range, limit int) ([]V, error) {
+	var out []V
+	err := p.index.GetAll(query, limit, &out)
+	return out, err
+}
+
+// ForEach calls fn with the primary key and value of every record referenced within query,
+// decoding one record at a time. Returning ErrStop from fn ends the iteration early without an error.
+func (p *TypedIndex[IK, V]) ForEach(query Range, fn func(primaryKey Key, value V) error) error {
+	return p.index.ForEach(query, func(primaryKey Key, decode Decoder) error {
+		var value V
+		err := decode(&value)
+		if err != nil {
+			return err
+		}
+		return fn(primaryKey, value)
+	})
+}
+
+// GetAllRecords returns the index key, primary key and value of the records selected by opts
+func (p *TypedIndex[IK, V]) GetAllRecords(opts GetAllOptions) ([]TypedEntry[IK, V], error) {
+	entries, err := p.index.GetAllRecords(opts)
+	if err != nil {
+		return nil, err
+	}
+	return typedEntries[IK, V](entries)
+}
+
+// GetPrimaryKey returns the primary key referenced by key
+func (p *TypedIndex[IK, V]) GetPrimaryKey(key IK) (Key, error) {
+	return p.index.GetExactKey(KeyOf(key))
+}
+
+func (p *TypedIndex[IK, V]) Count(query Range) (uint, error) {
+	return p.index.Count(query)
+}
+
+// OpenCursor iterates the index within query
+func (p *TypedIndex[IK, V]) OpenCursor(query Range, direction Direction) (*TypedIndexCursor[IK, V], error) {
+	c, err := p.index.OpenCursor(query, direction)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedIndexCursor[IK, V]{c}, nil
+}
+
+// ResumeCursor opens a cursor from a token made by a cursor of the same index
+func (p *TypedIndex[IK, V]) ResumeCursor(token string) (*TypedIndexCursor[IK, V], error) {
+	c, err := p.index.ResumeCursor(token)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedIndexCursor[IK, V]{c}, nil
+}
+
+// TypedIndexCursor iterates an index, decoding index keys as IK and values as V
+type TypedIndexCursor[IK KeyTypes, V any] struct {
+	Cursor MultiCursor
+}
+
+func (p *TypedIndexCursor[IK, V]) Continue() bool {
+	return p.Cursor.Continue()
+}
+
+func (p *TypedIndexCursor[IK, V]) Advance(count int) bool {
+	return p.Cursor.Advance(count)
+}
+
+// ContinueTo moves the cursor to the first entry for key, or to the next entry after it
+func (p *TypedIndexCursor[IK, V]) ContinueTo(key IK) error {
+	return p.Cursor.ContinueTo(KeyOf(key))
+}
+
+// Key returns the index key at the cursor's position
+func (p *TypedIndexCursor[IK, V]) Key() (IK, error) {
+	key, err := p.Cursor.Key()
+	if err != nil {
+		var out IK
+		return out, err
+	}
+	return keyAs[IK](key)
+}
+
+// PrimaryKey returns the primary key of the record at the cursor's position
+func (p *TypedIndexCursor[IK, V]) PrimaryKey() Key {
+	return p.Cursor.PrimaryKey()
+}
+
+// Token returns an opaque token for the cursor's position, see TypedIndex.ResumeCursor
+func (p *TypedIndexCursor[IK, V]) Token() (string, error) {
+	return p.Cursor.Token()
+}
+
+// Value returns the value at the cursor's position
+func (p *TypedIndexCursor[IK, V]) Value() (V, error) {
+	var out V
+	err := p.Cursor.Value(&out)
+	return out, err
+}
@@ -0,0 +1,149 @@
+package indexeddb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type typedTask struct {
+	Id     int    `json:"id,omitempty"`
+	Status string `json:"status"`
+}
+
+func TestTypedStore(t *testing.T) {
+	db, err := Open("typed", 1, t.TempDir()).Migrate(func(_ uint, h *MigrationTransaction) error {
+		s, err := h.CreateStore("tasks", StoreOptions{KeyPath: KeyPath{"id"}, AutoIncrement: true})
+		if err != nil {
+			return err
+		}
+		return s.CreateIndex("byStatus", IndexOptions{KeyPath: KeyPath{"status"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tr, err := db.Transaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks := NewTypedStore[int, typedTask](must(tr.Store("tasks")))
+
+	for _, status := range []string{"open", "done", "open"} {
+		if _, err := tasks.Put(typedTask{Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if key, err := tasks.Add(typedTask{Id: 10, Status: "done"}); err != nil || key != 10 {
+		t.Errorf("expected key 10, got %v %v", key, err)
+	}
+	if err := tasks.AddWithKey(10, typedTask{Id: 10}); !errors.Is(err, ErrConstraint) {
+		t.Errorf("expected a taken key to fail, got %v", err)
+	}
+
+	if task, err := tasks.Get(2); err != nil || task != (typedTask{2, "done"}) {
+		t.Errorf("unexpected task %v %v", task, err)
+	}
+	if all, err := tasks.GetAll(All(), 2); err != nil || !reflect.DeepEqual(all, []typedTask{{1, "open"}, {2, "done"}}) {
+		t.Errorf("unexpected tasks %v %v", all, err)
+	}
+	if keys, err := tasks.GetAllKeys(All(), 0); err != nil || !reflect.DeepEqual(keys, []int{1, 2, 3, 10}) {
+		t.Errorf("unexpected keys %v %v", keys, err)
+	}
+	if multi, err := tasks.GetMulti([]int{3, 4}); err != nil || !reflect.DeepEqual(multi, []typedTask{{3, "open"}, {}}) {
+		t.Errorf("unexpected tasks %v %v", multi, err)
+	}
+
+	c, err := tasks.OpenCursor(LowerBound(KeyOf(2), false), Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for c.Continue() {
+		key, err := c.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		task, err := c.Value()
+		if err != nil || task.Id != key {
+			t.Fatalf("expected the value of %d, got %v %v", key, task, err)
+		}
+		if task.Status == "done" {
+			if err := c.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	byStatus := NewTypedIndex[string, typedTask](must(tasks.Store().Index("byStatus")))
+	if open, err := byStatus.GetAll(Only(KeyOf("open")), 0); err != nil || !reflect.DeepEqual(open, []typedTask{{1, "open"}, {3, "open"}}) {
+		t.Errorf("unexpected open tasks %v %v", open, err)
+	}
+	if n, err := byStatus.Count(Only(KeyOf("done"))); err != nil || n != 0 {
+		t.Errorf("expected the cursor to delete done tasks, got %d %v", n, err)
+	}
+	ic, err := byStatus.OpenCursor(All(), Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ic.Continue() {
+		t.Fatal("expected an index entry")
+	}
+	if status, err := ic.Key(); err != nil || status != "open" {
+		t.Errorf("unexpected index key %v %v", status, err)
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := db.ReadonlyTransaction([]string{"tasks"}, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Commit()
+	readonly := NewTypedStore[int, typedTask](must(rt.Store("tasks")))
+	if _, err := readonly.Put(typedTask{Status: "open"}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected writes to a readonly store to fail, got %v", err)
+	}
+	if _, err := NewTypedStore[string, typedTask](must(rt.Store("tasks"))).GetKey(All()); !errors.Is(err, ErrData) {
+		t.Errorf("expected a numeric key not to convert to a string, got %v", err)
+	}
+	var ids []int
+	err = readonly.ForEach(All(), func(key int, task typedTask) error {
+		if task.Id != key {
+			t.Errorf("expected the value of %d, got %v", key, task)
+		}
+		ids = append(ids, key)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Errorf("expected tasks [1 3], got %v %v", ids, err)
+	}
+	entries, err := readonly.GetAllRecords(GetAllOptions{Direction: Prev})
+	if err != nil || len(entries) != 2 || entries[0].Key != 3 || entries[0].Value != (typedTask{3, "open"}) {
+		t.Errorf("expected task 3 first, got %v %v", entries, err)
+	}
+}
+
+func TestTypedKeys(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("x", 3600))
+	if !reflect.DeepEqual(KeyOf(when), KeyOf(when.UTC())) {
+		t.Errorf("expected the same instant to give the same key, got %v and %v", KeyOf(when), KeyOf(when.UTC()))
+	}
+
+	if k, err := keyAs[int](Key{2.0}); err != nil || k != 2 {
+		t.Errorf("expected 2, got %v %v", k, err)
+	}
+	if k, err := keyAs[float64](Key{1.5}); err != nil || k != 1.5 {
+		t.Errorf("expected 1.5, got %v %v", k, err)
+	}
+	for _, key := range []Key{{1.5}, {-1.0}, {300.0}} {
+		if _, err := keyAs[uint8](key); !errors.Is(err, ErrData) {
+			t.Errorf("expected %v not to fit a uint8, got %v", key, err)
+		}
+	}
+	if _, err := keyAs[int](Key{1e300}); !errors.Is(err, ErrData) {
+		t.Errorf("expected an out of range key to fail, got %v", err)
+	}
+}